@@ -0,0 +1,24 @@
+package api
+
+// Error codes of ResponseV2 and FieldError. They are stable: new codes can be added, but existing ones
+// will never change their meaning.
+const (
+	CodeNotFound              = "NOT_FOUND"
+	CodeMethodNotAllowed      = "METHOD_NOT_ALLOWED"
+	CodeOriginNotAllowed      = "ORIGIN_NOT_ALLOWED"
+	CodeContentTypeNotAllowed = "CONTENT_TYPE_NOT_ALLOWED"
+	CodeMalformedJSON         = "MALFORMED_JSON"
+	CodeRequestTooLarge       = "REQUEST_TOO_LARGE"
+	CodeValidationFailed      = "VALIDATION_FAILED"
+	CodeInvalidEmail          = "INVALID_EMAIL"
+	CodeCaptchaRequired       = "CAPTCHA_REQUIRED"
+	CodeCaptchaFailed         = "CAPTCHA_FAILED"
+	CodeReadError             = "READ_ERROR"
+	CodeUnknownError          = "UNKNOWN_ERROR"
+	CodeInternalError         = "INTERNAL_ERROR"
+	CodeGatewayTimeout        = "GATEWAY_TIMEOUT"
+	CodeServiceUnavailable    = "SERVICE_UNAVAILABLE"
+
+	// Codes only returned by the admin API
+	CodeUnauthorized = "UNAUTHORIZED"
+)
@@ -7,3 +7,21 @@ type Response struct {
 	Success bool   `json:"success"`
 	Err     string `json:"error,omitempty"`
 }
+
+// ResponseV2 represents the content that web-msg-handler will reply to the requests made to the /v2/ API.
+// Along with the fields of Response, it have a "code" field with a machine-readable code of the error
+// (see the Code constants) and, when the error is CodeValidationFailed, a "fields" field with every field
+// that failed the validation.
+type ResponseV2 struct {
+	Success bool         `json:"success"`
+	Code    string       `json:"code,omitempty"`
+	Err     string       `json:"error,omitempty"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError represents a field of the request that failed the validation.
+type FieldError struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+	Err   string `json:"error"`
+}
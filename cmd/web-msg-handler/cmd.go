@@ -1,18 +1,21 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"github.com/Miguel-Dorta/logolang"
 	"github.com/Miguel-Dorta/si"
 	"github.com/Miguel-Dorta/web-msg-handler/internal"
 	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/logger"
 	"github.com/Miguel-Dorta/web-msg-handler/pkg/server"
 	"github.com/spf13/cobra"
 	"golang.org/x/sys/unix"
-	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"time"
 )
 
 var (
@@ -20,12 +23,18 @@ var (
 	cmdRoot = &cobra.Command{
 		Use: "web-msg-handler",
 		Run: root,
+		PersistentPreRun: setUpConfig,
 	}
 	cmdReload = &cobra.Command{
 		Use: "reload",
-		Short: "reload site configs",
+		Short: "reload config and site configs",
 		Run: reload,
 	}
+	cmdReopenLogs = &cobra.Command{
+		Use: "reopen-logs",
+		Short: "reopen log files",
+		Run: reopenLogs,
+	}
 	cmdRestart = &cobra.Command{
 		Use: "restart",
 		Short: "restart web-msg-handler",
@@ -43,60 +52,192 @@ var (
 	}
 
 	installationPath string
+
+	// foreground reports if the service must run in foreground mode (see setForeground)
+	foreground bool
+
+	// stopPollInterval is the interval for checking if the instance stopped has finished
+	stopPollInterval = 100 * time.Millisecond
+
+	// stopMargin is the time that the instance stopped has to finish after its shutdown_timeout,
+	// i.e. for killing its senders
+	stopMargin = 10 * time.Second
+
+	// pidFileLock is the PID file of this instance, kept open for holding its lock
+	pidFileLock *os.File
 )
 
 func init() {
 	cmdRoot.PersistentFlags().StringVarP(&installationPath, "installation-path", "p", config.Directory, "set installation path")
-	cmdRoot.AddCommand(cmdReload, cmdRestart, cmdStop, cmdVersion)
+	for _, s := range config.Settings() {
+		if s.Secret {
+			continue
+		}
+		cmdRoot.PersistentFlags().Var(&settingValue{kind: s.Kind}, s.Flag(),
+			fmt.Sprintf("override setting %s of the config (env %s)", s.Key, s.Env()))
+		if s.Kind == reflect.Bool {
+			cmdRoot.PersistentFlags().Lookup(s.Flag()).NoOptDefVal = "true"
+		}
+	}
+	cmdRoot.Flags().BoolVarP(&foreground, "foreground", "f", false,
+		"run without PID file, logging to stdout and stderr in JSON (use control_socket for reloading)")
+	cmdRoot.AddCommand(cmdReload, cmdReopenLogs, cmdRestart, cmdStop, cmdVersion)
+}
+
+// settingValue is the value of the flag of a config setting. It's validated when loading the config.
+type settingValue struct {
+	value string
+	kind  reflect.Kind
+}
+
+func (v *settingValue) String() string {
+	return v.value
+}
+
+func (v *settingValue) Set(s string) error {
+	v.value = s
+	return nil
+}
+
+func (v *settingValue) Type() string {
+	switch v.kind {
+	case reflect.Int64:
+		return "int"
+	case reflect.Slice:
+		return "strings"
+	}
+	return v.kind.String()
+}
+
+// setUpConfig will execute before any command.
+// It sets the config directory to the installation path, and overrides the settings of the config with the flags provided
+// (secret settings have no flag).
+func setUpConfig(cmd *cobra.Command, _ []string) {
+	config.Directory = installationPath
+	for _, s := range config.Settings() {
+		if f := cmd.Flags().Lookup(s.Flag()); f != nil && f.Changed {
+			config.Overrides[s.Key] = f.Value.String()
+		}
+	}
 }
 
 // root will execute when no command is given.
 // It starts the service if no other instance is running.
-func root(_ *cobra.Command, _ []string) {
+// In foreground mode (see setForeground), it doesn't register this instance in its PID file.
+// When it's started by the upgrade of another instance, it takes the PID file of that instance.
+func root(cmd *cobra.Command, _ []string) {
+	if foreground {
+		setForeground()
+	}
+
 	c := loadConf()
-	if err := si.Register(c.PIDFile); err != nil {
-		log.Error(err.Error())
-		os.Exit(1)
+	if !foreground {
+		if f := server.Inherited(server.InheritedPIDFile); f != nil {
+			pidFileLock = f
+		} else {
+			alias := getAlias(c.PIDFile)
+			if err := si.Register(alias); err != nil {
+				log.Error(err.Error())
+				os.Exit(1)
+			}
+			lockPIDFile(alias)
+		}
+		server.PIDFile = pidFileLock
 	}
 
-	server.Run(c.Port, log)
+	server.Args = startArgs(cmd)
+	server.Run(c, log)
+}
+
+// setForeground overrides the log settings for the foreground mode (i.e. in containers or systemd):
+// the logs are written to stdout and stderr in JSON, unless another format is requested by a flag or environment variable.
+func setForeground() {
+	config.Overrides["log_output_file"] = ""
+	config.Overrides["log_error_file"] = ""
+	if _, ok := config.Overrides["log_format"]; !ok && os.Getenv(config.EnvPrefix+"LOG_FORMAT") == "" {
+		config.Overrides["log_format"] = logger.FormatJSON
+	}
 }
 
 // reload will execute when "reload" command is given.
-// It will send a SIGUSR1 signal to a running process in order to reload its sites configs.
+// It will send a SIGUSR1 signal to a running process in order to reload its config and sites configs.
 func reload(_ *cobra.Command, _ []string) {
-	c := loadConf()
-	p, err := si.Find(c.PIDFile)
+	signalRunning(unix.SIGUSR1, server.ControlReload)
+}
+
+// reopenLogs will execute when "reopen-logs" command is given.
+// It will send a SIGHUP signal to a running process in order to reopen its log files (i.e. after being rotated).
+func reopenLogs(_ *cobra.Command, _ []string) {
+	signalRunning(unix.SIGHUP, server.ControlReopenLogs)
+}
+
+// signalRunning sends the signal or control command provided to the running instance of web-msg-handler
+// (see sendRunning). It fails if there is no running instance.
+func signalRunning(sig os.Signal, command string) {
+	running, err := sendRunning(loadConfUnresolved(), sig, command)
 	if err != nil {
-		log.Errorf("error finding other running instance of web-msg-handler: %s", err)
+		log.Errorf("error sending %s to other instance of web-msg-handler: %s", command, err)
 		os.Exit(1)
 	}
 
-	if p == nil {
+	if !running {
 		log.Error("there are no running instance of web-msg-handler")
 		os.Exit(1)
 	}
 
-	if err := p.Signal(unix.SIGUSR1); err != nil {
-		log.Errorf("error sending reload signal to other instance of web-msg-handler: %s", err)
-		os.Exit(1)
+	log.Debugf("%s delivered. Check the log of web-msg-handler for detecting error", command)
+}
+
+// sendRunning sends the control command provided to the running instance of web-msg-handler through the control socket
+// of the config provided, or the signal provided to the PID of its PID file if it doesn't define a control socket.
+// It returns false if there is no running instance.
+func sendRunning(c *config.Config, sig os.Signal, command string) (bool, error) {
+	if c.ControlSocket != "" {
+		err := server.SendControl(c.ControlSocket, command)
+		if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ECONNREFUSED) {
+			return false, nil
+		}
+		return err == nil, err
 	}
 
-	log.Debug("reload signal delivered. Check the log of web-msg-handler for detecting error")
+	p, err := si.Find(getAlias(c.PIDFile))
+	if err != nil {
+		return false, fmt.Errorf("error finding other running instance of web-msg-handler: %w", err)
+	}
+	if p == nil {
+		return false, nil
+	}
+	return true, p.Signal(sig)
 }
 
 // restart will execute when "restart" command is given.
 // It will stop other instance and start this one.
-func restart(_ *cobra.Command, _ []string) {
+func restart(cmd *cobra.Command, args []string) {
 	stop(nil, nil)
-	root(nil, nil)
+	root(cmd, args)
 }
 
 // stop will execute when "stop" command is given.
 // It will stop another instance of web-msg-handler if it's running.
+// It waits until the instance finishes, or until it stops listening in its control socket if the config defines one,
+// for its shutdown_timeout plus stopMargin at most.
 func stop(_ *cobra.Command, _ []string) {
-	c := loadConf()
-	p, err := si.Find(c.PIDFile)
+	c := loadConfUnresolved()
+	deadline := time.Now().Add(time.Duration(c.ShutdownTimeout)*time.Second + stopMargin)
+	if c.ControlSocket != "" {
+		running, err := sendRunning(c, unix.SIGTERM, server.ControlStop)
+		if err != nil {
+			log.Errorf("error sending stop to the other instance of web-msg-handler: %s", err)
+			os.Exit(1)
+		}
+		for running {
+			waitStop(deadline)
+			running = controlListening(c.ControlSocket)
+		}
+		return
+	}
+
+	p, err := si.Find(getAlias(c.PIDFile))
 	if err != nil {
 		log.Errorf("error finding other running instance of web-msg-handler: %s", err)
 		os.Exit(1)
@@ -111,10 +252,31 @@ func stop(_ *cobra.Command, _ []string) {
 		os.Exit(1)
 	}
 
-	if _, err := p.Wait(); err != nil {
-		log.Errorf("the other instance of web-msg-handler returned an error: %s", err)
+	// It's not a child of this process, so it cannot be waited (p.Wait fails with ECHILD)
+	for p.Signal(unix.Signal(0)) == nil {
+		waitStop(deadline)
+	}
+}
+
+// waitStop waits stopPollInterval before checking again if the instance stopped has finished.
+// It fails if the deadline provided has passed.
+func waitStop(deadline time.Time) {
+	if time.Now().After(deadline) {
+		log.Error("the other instance of web-msg-handler didn't stop in time. Check its log")
 		os.Exit(1)
 	}
+	time.Sleep(stopPollInterval)
+}
+
+// controlListening reports if an instance is listening in the control socket provided.
+// The socket is left behind by the instances that don't stop gracefully, so its existence is not enough.
+func controlListening(path string) bool {
+	conn, err := net.DialTimeout("unix", path, stopPollInterval)
+	if err != nil {
+		return !errors.Is(err, unix.ENOENT) && !errors.Is(err, unix.ECONNREFUSED)
+	}
+	_ = conn.Close()
+	return true
 }
 
 // version will execute when "version" command is given.
@@ -123,40 +285,53 @@ func version(_ *cobra.Command, _ []string) {
 	fmt.Println(internal.Version)
 }
 
-// loadConf returns the config that exists in installationPath,
-// apply it to this package logger and
-// return the config with the PID alias in config.PIDFile
+// loadConf returns the config that exists in installationPath
+// and apply it to this package logger
 func loadConf() *config.Config {
+	return setUpConf(config.Load)
+}
+
+// loadConfUnresolved returns the config like loadConf, but without resolving its references to secrets
+// (see config.LoadUnresolved). It's used by the commands that don't start the server, which don't need them.
+func loadConfUnresolved() *config.Config {
+	return setUpConf(config.LoadUnresolved)
+}
+
+// setUpConf loads the config of installationPath with the function provided and applies it to this package logger
+func setUpConf(load func() (*config.Config, error)) *config.Config {
 	config.Directory = installationPath
 
-	c, err := config.Load()
+	c, err := load()
 	if err != nil {
 		log.Criticalf("error loading config: %s", err)
 		os.Exit(1)
 	}
 
-	stdout := setWriter(c.LogOutFile, os.Stdout)
-	stderr := setWriter(c.LogErrFile, os.Stderr)
-	log = logolang.NewLoggerWriters(stdout, stdout, stderr, stderr)
-	log.Color = false
-	log.Level = c.Verbose
-
-	c.PIDFile = getAlias(c.PIDFile)
+	l, err := logger.Open(c.LogOutFile, c.LogErrFile, c.Verbose, c.LogFormat)
+	if err != nil {
+		log.Criticalf("error loading config: %s", err)
+		os.Exit(1)
+	}
+	_ = log.Replace(l)
 	return c
 }
 
-// setWriter will return an io.Writer for the file of the specified path or the writer provided if path == ""
-func setWriter(path string, w io.Writer) io.Writer {
-	if path == "" {
-		return &logolang.SafeWriter{W:w}
+// lockPIDFile locks the PID file of the alias provided until the program ends.
+// si.Register releases its lock when it returns, and si.Find will not find an instance whose PID file is not locked,
+// making impossible for other commands to signal this instance.
+// It's opened for writing too, so the PID of a new instance can be written when upgrading.
+func lockPIDFile(alias string) {
+	f, err := os.OpenFile(filepath.Join(si.Dir, alias+".pid"), os.O_RDWR, 0)
+	if err != nil {
+		log.Criticalf("error opening pid file: %s", err)
+		os.Exit(1)
 	}
 
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		log.Criticalf("error opening log file %s: %s", path, err)
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		log.Criticalf("error locking pid file: %s", err)
 		os.Exit(1)
 	}
-	return &logolang.SafeWriter{W: f}
+	pidFileLock = f
 }
 
 // getAlias gets a path for a pith file, sets si.Dir to that path's parent dir,
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+)
+
+var (
+	// Cobra commands
+	cmdConfig = &cobra.Command{
+		Use:   "config",
+		Short: "manage the configs",
+	}
+	cmdConfigCheck = &cobra.Command{
+		Use:   "check",
+		Short: "check the config and the site configs, reporting every problem found",
+		Long: "Check the config and the site configs, reporting every problem found.\n" +
+			"With --effective, the configs of the sites merged with their profiles are printed too.",
+		Args: cobra.NoArgs,
+		Run:  configCheck,
+	}
+
+	// configEffective reports if the effective configs of the sites must be printed
+	configEffective bool
+)
+
+func init() {
+	cmdConfigCheck.Flags().BoolVarP(&configEffective, "effective", "e", false,
+		"print the configs of the sites merged with their profiles (secrets are not resolved)")
+	cmdConfig.AddCommand(cmdConfigCheck)
+	cmdRoot.AddCommand(cmdConfig)
+}
+
+// configCheck will execute when "config check" command is given.
+// It loads the config and the site configs like web-msg-handler does when starting or reloading,
+// and prints the problems found with their file and line. It exits with status 1 if there are any.
+func configCheck(_ *cobra.Command, _ []string) {
+	config.Directory = installationPath
+
+	var problems []config.Problem
+	if _, err := config.Load(); err != nil {
+		problems = append(problems, checkProblems(err)...)
+	}
+	sites, err := config.LoadSites()
+	if err != nil {
+		problems = append(problems, checkProblems(err)...)
+	}
+
+	if len(problems) != 0 {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		fmt.Printf("%d problems found\n", len(problems))
+		os.Exit(1)
+	}
+
+	if configEffective {
+		ids := make([]string, 0, len(sites))
+		for id := range sites {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			fmt.Printf("# %s\n%s\n", sites[id].Path, sites[id].EffectiveConfig())
+		}
+	}
+	fmt.Printf("Config OK (%d sites)\n", len(sites))
+}
+
+// checkProblems returns the problems of the error returned when loading a config
+func checkProblems(err error) []config.Problem {
+	var checkErr *config.CheckError
+	if errors.As(err, &checkErr) {
+		return checkErr.Problems
+	}
+	return []config.Problem{{Err: err}}
+}
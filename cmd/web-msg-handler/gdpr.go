@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/archive"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/sanitation"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var (
+	// Cobra commands
+	cmdGDPR = &cobra.Command{
+		Use:   "gdpr",
+		Short: "manage the data stored about a submitter",
+	}
+	cmdGDPRErase = &cobra.Command{
+		Use:   "erase",
+		Short: "erase everything stored about the submitter with the mail provided",
+		Args:  cobra.NoArgs,
+		Run:   gdprErase,
+	}
+	cmdGDPRExport = &cobra.Command{
+		Use:   "export",
+		Short: "print in JSON everything stored about the submitter with the mail provided",
+		Args:  cobra.NoArgs,
+		Run:   gdprExport,
+	}
+
+	// gdprMail is the mail of the submitter
+	gdprMail string
+)
+
+func init() {
+	for _, cmd := range []*cobra.Command{cmdGDPRErase, cmdGDPRExport} {
+		cmd.Flags().StringVar(&gdprMail, "mail", "", "mail of the submitter (case-insensitive)")
+		_ = cmd.MarkFlagRequired("mail")
+	}
+	cmdGDPR.AddCommand(cmdGDPRErase, cmdGDPRExport)
+	cmdRoot.AddCommand(cmdGDPR)
+}
+
+// gdprErase will execute when "gdpr erase" command is given.
+// It deletes the archived messages of the submitter.
+func gdprErase(_ *cobra.Command, _ []string) {
+	logSensitive := loadGDPRConf()
+	n, err := archive.Delete(archive.Filter{Mail: gdprMail})
+	if err != nil {
+		log.Criticalf("error erasing messages: %s", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Erased %d messages of %s\n", n, gdprMail)
+
+	warnWithoutMail()
+	warnLogSensitive(logSensitive)
+}
+
+// gdprExport will execute when "gdpr export" command is given.
+// It prints in JSON the archived messages of the submitter.
+func gdprExport(_ *cobra.Command, _ []string) {
+	logSensitive := loadGDPRConf()
+	records, err := archive.List(archive.Filter{Mail: gdprMail})
+	if err != nil {
+		log.Criticalf("error listing messages: %s", err)
+		os.Exit(1)
+	}
+	if records == nil {
+		records = []*archive.Record{}
+	}
+
+	data, _ := json.MarshalIndent(map[string]interface{}{
+		"mail":     gdprMail,
+		"messages": records,
+	}, "", "  ")
+	fmt.Println(string(data))
+
+	warnWithoutMail()
+	warnLogSensitive(logSensitive)
+}
+
+// loadGDPRConf checks the mail provided, loads the config and returns if it enables log_sensitive
+func loadGDPRConf() bool {
+	if !sanitation.IsValidMail(gdprMail) {
+		log.Criticalf("invalid mail: %s", gdprMail)
+		os.Exit(1)
+	}
+	return loadConfUnresolved().LogSensitive
+}
+
+// warnWithoutMail warns about the archived messages without mail (the ones of sites with encryption),
+// which can't be matched by the gdpr commands
+func warnWithoutMail() {
+	records, err := archive.List(archive.Filter{})
+	if err != nil {
+		log.Errorf("error listing messages: %s", err)
+		return
+	}
+	n := 0
+	for _, r := range records {
+		if r.Mail == "" {
+			n++
+		}
+	}
+	if n != 0 {
+		log.Errorf("%d archived messages (of sites with encryption) have no mail and can't be matched: "+
+			"they're only deleted by the retention_days of their site", n)
+	}
+}
+
+// warnLogSensitive warns that the log files are not managed by the gdpr commands if log_sensitive is enabled
+func warnLogSensitive(logSensitive bool) {
+	if logSensitive {
+		log.Error("log_sensitive is enabled: the log files may contain data of the submitter and must be reviewed manually")
+	}
+}
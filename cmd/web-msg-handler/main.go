@@ -2,14 +2,14 @@ package main
 
 import (
 	"github.com/Miguel-Dorta/logolang"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/logger"
 	"os"
 )
 
-var log *logolang.Logger
+var log *logger.Logger
 
 func init() {
-	log = logolang.NewLogger()
-	log.Color = false
+	log, _ = logger.New(&logolang.SafeWriter{W: os.Stdout}, &logolang.SafeWriter{W: os.Stderr}, logolang.LevelError, logger.FormatText)
 }
 
 func main() {
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/server"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+var (
+	// Cobra commands
+	cmdMaintenance = &cobra.Command{
+		Use:   "maintenance",
+		Short: "manage the maintenance of every site",
+	}
+	cmdMaintenanceOn = &cobra.Command{
+		Use:   "on",
+		Short: "reject the messages of every site with the message provided",
+		Args:  cobra.NoArgs,
+		Run:   maintenanceOn,
+	}
+	cmdMaintenanceOff = &cobra.Command{
+		Use:   "off",
+		Short: "accept the messages again",
+		Args:  cobra.NoArgs,
+		Run:   maintenanceOff,
+	}
+
+	// maintenanceMessage is the message replied to the visitors during the maintenance
+	maintenanceMessage string
+)
+
+func init() {
+	cmdMaintenanceOn.Flags().StringVarP(&maintenanceMessage, "message", "m", "",
+		"message replied to the visitors (the maintenance_message of each site or a default one if empty). "+
+			"If not provided, the one of the config is kept")
+	cmdMaintenance.AddCommand(cmdMaintenanceOn, cmdMaintenanceOff)
+	cmdRoot.AddCommand(cmdMaintenance)
+}
+
+// maintenanceOn will execute when "maintenance on" command is given.
+// It enables the maintenance in the config and reloads the running instance.
+// The maintenance_message of the config is only changed if --message is provided.
+func maintenanceOn(cmd *cobra.Command, _ []string) {
+	var message *string
+	if cmd.Flags().Changed("message") {
+		message = &maintenanceMessage
+	}
+	setMaintenance(true, message)
+}
+
+// maintenanceOff will execute when "maintenance off" command is given.
+// It disables the maintenance in the config and reloads the running instance, keeping its maintenance_message.
+func maintenanceOff(_ *cobra.Command, _ []string) {
+	setMaintenance(false, nil)
+}
+
+// setMaintenance writes the maintenance settings provided in the config (see config.SetMaintenance),
+// and reloads the running instance (if any) so it applies them.
+func setMaintenance(enabled bool, message *string) {
+	c := loadConfUnresolved()
+	if err := config.SetMaintenance(enabled, message); err != nil {
+		log.Criticalf("error writing config: %s", err)
+		os.Exit(1)
+	}
+
+	running, err := sendRunning(c, unix.SIGUSR1, server.ControlReload)
+	if err != nil {
+		log.Errorf("error sending reload to other instance of web-msg-handler: %s", err)
+		os.Exit(1)
+	}
+	if !running {
+		fmt.Println("Config updated. There are no running instance of web-msg-handler, it will be applied when started")
+		return
+	}
+	fmt.Println("Config updated and reload delivered. Check the log of web-msg-handler for detecting error")
+}
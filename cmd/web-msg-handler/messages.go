@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/archive"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// Output formats of the messages commands
+	formatText = "text"
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+var (
+	// Cobra commands
+	cmdMessages = &cobra.Command{
+		Use:   "messages",
+		Short: "read the archived messages",
+	}
+	cmdMessagesList = &cobra.Command{
+		Use:   "list",
+		Short: "list the archived messages",
+		Args:  cobra.NoArgs,
+		Run:   messagesList,
+	}
+	cmdMessagesShow = &cobra.Command{
+		Use:   "show <id>",
+		Short: "show an archived message",
+		Args:  cobra.ExactArgs(1),
+		Run:   messagesShow,
+	}
+	cmdMessagesExport = &cobra.Command{
+		Use:   "export",
+		Short: "export the archived messages with all their fields",
+		Args:  cobra.NoArgs,
+		Run:   messagesExport,
+	}
+
+	// Flags of the messages commands. Each command has its own format, since their defaults are different.
+	listFormat, showFormat, exportFormat string
+	messagesSite, messagesSince          string
+)
+
+func init() {
+	for _, cmd := range []*cobra.Command{cmdMessagesList, cmdMessagesExport} {
+		cmd.Flags().StringVar(&messagesSite, "site", "", "only messages of the site ID provided")
+		cmd.Flags().StringVar(&messagesSince, "since", "",
+			"only messages received since the date (2006-01-02 or RFC 3339) or duration (i.e. 72h) provided")
+	}
+	cmdMessagesList.Flags().StringVar(&listFormat, "format", formatText, "output format: text, csv or json")
+	cmdMessagesShow.Flags().StringVar(&showFormat, "format", formatText, "output format: text or json")
+	cmdMessagesExport.Flags().StringVar(&exportFormat, "format", formatJSON, "output format: csv or json")
+
+	cmdMessages.AddCommand(cmdMessagesList, cmdMessagesShow, cmdMessagesExport)
+	cmdRoot.AddCommand(cmdMessages)
+}
+
+// messagesList will execute when "messages list" command is given.
+// It prints a summary of the archived messages that match the filters.
+func messagesList(_ *cobra.Command, _ []string) {
+	if listFormat != formatText && listFormat != formatCSV && listFormat != formatJSON {
+		invalidFormat(listFormat)
+	}
+	exitOnError(writeList(os.Stdout, listRecords(), listFormat))
+}
+
+// messagesShow will execute when "messages show" command is given.
+// It prints the archived message with the ID provided.
+func messagesShow(_ *cobra.Command, args []string) {
+	if showFormat != formatText && showFormat != formatJSON {
+		invalidFormat(showFormat)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		log.Criticalf("invalid message ID: %s", args[0])
+		os.Exit(1)
+	}
+
+	loadConfUnresolved()
+	r, err := archive.Get(id)
+	if err != nil {
+		log.Criticalf("error reading message %d: %s", id, err)
+		os.Exit(1)
+	}
+	exitOnError(writeRecord(os.Stdout, r, showFormat))
+}
+
+// messagesExport will execute when "messages export" command is given.
+// It prints every field of the archived messages that match the filters.
+func messagesExport(_ *cobra.Command, _ []string) {
+	if exportFormat != formatCSV && exportFormat != formatJSON {
+		invalidFormat(exportFormat)
+	}
+	exitOnError(writeRecords(os.Stdout, listRecords(), exportFormat))
+}
+
+// listRecords returns the archived messages that match the filters of the flags
+func listRecords() []*archive.Record {
+	f := archive.Filter{SiteID: messagesSite}
+	if messagesSince != "" {
+		since, err := parseSince(messagesSince, time.Now())
+		if err != nil {
+			log.Criticalf("invalid --since: %s", err)
+			os.Exit(1)
+		}
+		f.Since = since
+	}
+
+	loadConfUnresolved()
+	records, err := archive.List(f)
+	if err != nil {
+		log.Criticalf("error listing messages: %s", err)
+		os.Exit(1)
+	}
+	return records
+}
+
+// writeList writes a summary of the records provided in w in the format provided (text, CSV or JSON).
+// The CSV and JSON formats have every field (see writeRecords).
+func writeList(w io.Writer, records []*archive.Record, format string) error {
+	if format != formatText {
+		return writeRecords(w, records, format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tSITE\tNAME\tMAIL\tOUTCOME")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			r.ID, r.Time.Format(time.RFC3339), r.SiteID, r.Name, r.Mail, r.Outcome)
+	}
+	return tw.Flush()
+}
+
+// writeRecord writes the record provided in w in the format provided (text or JSON)
+func writeRecord(w io.Writer, r *archive.Record, format string) error {
+	if format == formatJSON {
+		data, _ := json.MarshalIndent(r, "", "  ")
+		_, err := fmt.Fprintln(w, string(data))
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "ID: %d\nTime: %s\nSite: %s\nName: %s\nMail: %s\nClient IP: %s\nRequest ID: %s\nOutcome: %s\n",
+		r.ID, r.Time.Format(time.RFC3339), r.SiteID, r.Name, r.Mail, r.ClientIP, r.RequestID, r.Outcome)
+	if r.SenderError != "" {
+		fmt.Fprintf(buf, "Sender error: %s\n", r.SenderError)
+	}
+	fmt.Fprintf(buf, "\n%s\n", r.Msg)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeRecords writes every field of the records provided in w in the format provided (CSV or JSON)
+func writeRecords(w io.Writer, records []*archive.Record, format string) error {
+	if format == formatJSON {
+		if records == nil {
+			records = []*archive.Record{}
+		}
+		data, _ := json.MarshalIndent(records, "", "  ")
+		_, err := fmt.Fprintln(w, string(data))
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "time", "site_id", "name", "mail", "msg", "client_ip", "request_id", "outcome", "sender_error"})
+	for _, r := range records {
+		_ = cw.Write([]string{
+			strconv.FormatUint(r.ID, 10), r.Time.Format(time.RFC3339), r.SiteID, r.Name, r.Mail, r.Msg,
+			r.ClientIP, r.RequestID, r.Outcome, r.SenderError,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseSince parses the value of the --since flag. It can be a date (2006-01-02), a RFC 3339 time,
+// or a duration before now (i.e. 72h).
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be a date, a RFC 3339 time or a duration: %s", s)
+	}
+	return now.Add(-d), nil
+}
+
+// invalidFormat logs that the format provided is not valid for the command and exits
+func invalidFormat(format string) {
+	log.Criticalf("invalid format: %s", format)
+	os.Exit(1)
+}
+
+// exitOnError logs the error provided and exits if it's not nil
+func exitOnError(err error) {
+	if err != nil {
+		log.Criticalf("error writing output: %s", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/archive"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		s        string
+		expected time.Time
+		err      bool
+	}{
+		{"2021-03-04", time.Date(2021, 3, 4, 0, 0, 0, 0, time.Local), false},
+		{"2021-03-04T05:06:07Z", time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), false},
+		{"2021-03-04T05:06:07+02:00", time.Date(2021, 3, 4, 3, 6, 7, 0, time.UTC), false},
+		{"72h", now.Add(-72 * time.Hour), false},
+		{"90m", now.Add(-90 * time.Minute), false},
+		{"3d", time.Time{}, true},
+		{"2021-03-04 05:06", time.Time{}, true},
+		{"", time.Time{}, true},
+	} {
+		since, err := parseSince(test.s, now)
+		if (err != nil) != test.err {
+			t.Errorf("unexpected error parsing %q: %v", test.s, err)
+			continue
+		}
+		if !since.Equal(test.expected) {
+			t.Errorf("unexpected time of %q: %s (expected %s)", test.s, since, test.expected)
+		}
+	}
+}
+
+func TestListRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	installationPath = dir
+	loadConfUnresolved()
+	defer func() { messagesSite, messagesSince = "", "" }()
+
+	now := time.Now()
+	for _, r := range []*archive.Record{
+		{Time: now.Add(-72 * time.Hour), SiteID: "a", Outcome: archive.OutcomeSuccess},
+		{Time: now.Add(-time.Hour), SiteID: "a", Outcome: archive.OutcomeSuccess},
+		{Time: now.Add(-time.Hour), SiteID: "b", Outcome: archive.OutcomeSuccess},
+	} {
+		if err := archive.Save(r); err != nil {
+			t.Fatalf("error saving record: %s", err)
+		}
+	}
+
+	for _, test := range []struct {
+		site, since string
+		expected    []uint64
+	}{
+		{"", "", []uint64{1, 2, 3}},
+		{"a", "", []uint64{1, 2}},
+		{"", "24h", []uint64{2, 3}},
+		{"a", "24h", []uint64{2}},
+		{"a", now.Add(-48 * time.Hour).Format(time.RFC3339), []uint64{2}},
+		{"c", "", nil},
+	} {
+		messagesSite, messagesSince = test.site, test.since
+		records := listRecords()
+		ids := make([]uint64, 0, len(records))
+		for _, r := range records {
+			ids = append(ids, r.ID)
+		}
+		if len(ids) != len(test.expected) {
+			t.Errorf("unexpected messages of site %q since %q: %v", test.site, test.since, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.expected[i] {
+				t.Errorf("unexpected messages of site %q since %q: %v", test.site, test.since, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestWriteMessages(t *testing.T) {
+	records := []*archive.Record{
+		{ID: 1, Time: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), SiteID: "a", Name: "John", Mail: "john@example.com",
+			Msg: "Hi, \"there\"\nBye", ClientIP: "127.0.0.1", RequestID: "r1", Outcome: archive.OutcomeSuccess},
+		{ID: 2, Time: time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC), SiteID: "b", Name: "Jane", Mail: "jane@example.com",
+			Msg: "Hello", ClientIP: "::1", RequestID: "r2", Outcome: archive.OutcomeFailed, SenderError: "exit status 1"},
+	}
+	const csvOutput = "id,time,site_id,name,mail,msg,client_ip,request_id,outcome,sender_error\n" +
+		"1,2021-03-04T05:06:07Z,a,John,john@example.com,\"Hi, \"\"there\"\"\nBye\",127.0.0.1,r1,success,\n" +
+		"2,2021-03-05T00:00:00Z,b,Jane,jane@example.com,Hello,::1,r2,failed,exit status 1\n"
+
+	for _, test := range []struct {
+		name     string
+		write    func(*bytes.Buffer) error
+		expected string
+	}{
+		{"list text", func(w *bytes.Buffer) error { return writeList(w, records, formatText) },
+			"ID  TIME                  SITE  NAME  MAIL              OUTCOME\n" +
+				"1   2021-03-04T05:06:07Z  a     John  john@example.com  success\n" +
+				"2   2021-03-05T00:00:00Z  b     Jane  jane@example.com  failed\n"},
+		{"list csv", func(w *bytes.Buffer) error { return writeList(w, records, formatCSV) }, csvOutput},
+		{"export csv", func(w *bytes.Buffer) error { return writeRecords(w, records, formatCSV) }, csvOutput},
+		{"export csv empty", func(w *bytes.Buffer) error { return writeRecords(w, nil, formatCSV) },
+			"id,time,site_id,name,mail,msg,client_ip,request_id,outcome,sender_error\n"},
+		{"export json empty", func(w *bytes.Buffer) error { return writeRecords(w, nil, formatJSON) }, "[]\n"},
+		{"export json", func(w *bytes.Buffer) error { return writeRecords(w, records[1:], formatJSON) },
+			"[\n  {\n    \"id\": 2,\n    \"time\": \"2021-03-05T00:00:00Z\",\n    \"site_id\": \"b\",\n" +
+				"    \"name\": \"Jane\",\n    \"mail\": \"jane@example.com\",\n    \"msg\": \"Hello\",\n" +
+				"    \"client_ip\": \"::1\",\n    \"request_id\": \"r2\",\n    \"outcome\": \"failed\",\n" +
+				"    \"sender_error\": \"exit status 1\"\n  }\n]\n"},
+		{"show text", func(w *bytes.Buffer) error { return writeRecord(w, records[1], formatText) },
+			"ID: 2\nTime: 2021-03-05T00:00:00Z\nSite: b\nName: Jane\nMail: jane@example.com\nClient IP: ::1\n" +
+				"Request ID: r2\nOutcome: failed\nSender error: exit status 1\n\nHello\n"},
+		{"show json", func(w *bytes.Buffer) error { return writeRecord(w, records[0], formatJSON) },
+			"{\n  \"id\": 1,\n  \"time\": \"2021-03-04T05:06:07Z\",\n  \"site_id\": \"a\",\n  \"name\": \"John\",\n" +
+				"  \"mail\": \"john@example.com\",\n  \"msg\": \"Hi, \\\"there\\\"\\nBye\",\n  \"client_ip\": \"127.0.0.1\",\n" +
+				"  \"request_id\": \"r1\",\n  \"outcome\": \"success\"\n}\n"},
+	} {
+		buf := new(bytes.Buffer)
+		if err := test.write(buf); err != nil {
+			t.Errorf("error writing %s: %s", test.name, err)
+			continue
+		}
+		if buf.String() != test.expected {
+			t.Errorf("unexpected output of %s:\n-> Expected: %q\n-> Found: %q", test.name, test.expected, buf.String())
+		}
+	}
+}
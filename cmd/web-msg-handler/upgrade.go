@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Miguel-Dorta/si"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+	"os"
+	"time"
+)
+
+// cmdUpgrade is the Cobra command "upgrade"
+var cmdUpgrade = &cobra.Command{
+	Use:   "upgrade",
+	Short: "replace the running instance with the installed executable without closing its port",
+	Args:  cobra.NoArgs,
+	Run:   upgrade,
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdUpgrade)
+}
+
+// upgrade will execute when "upgrade" command is given.
+// It sends a SIGUSR2 signal (or the upgrade control command) to the running instance, which starts a new one
+// that takes its listeners and PID file, and then shuts down gracefully. It waits until the PID file has the new PID.
+// The site configs are checked first, since the new instance would fail to start with them.
+func upgrade(_ *cobra.Command, _ []string) {
+	c := loadConfUnresolved()
+	if _, err := config.LoadSites(); err != nil {
+		log.Errorf("error loading sites config: %s", err)
+		os.Exit(1)
+	}
+
+	alias := getAlias(c.PIDFile)
+	old, err := si.Find(alias)
+	if err != nil {
+		log.Errorf("error finding other running instance of web-msg-handler: %s", err)
+		os.Exit(1)
+	}
+	if old == nil {
+		log.Error("there are no running instance of web-msg-handler with a PID file (the foreground mode cannot be upgraded)")
+		os.Exit(1)
+	}
+
+	if _, err := sendRunning(c, unix.SIGUSR2, server.ControlUpgrade); err != nil {
+		log.Errorf("error sending upgrade to other instance of web-msg-handler: %s", err)
+		os.Exit(1)
+	}
+
+	for deadline := time.Now().Add(server.UpgradeTimeout + time.Second); time.Now().Before(deadline); {
+		time.Sleep(stopPollInterval)
+		// The PID file may be read while it's being written
+		p, err := si.Find(alias)
+		if err != nil {
+			continue
+		}
+		if p == nil {
+			log.Error("the running instance of web-msg-handler stopped while upgrading. Check its log")
+			os.Exit(1)
+		}
+		if p.Pid != old.Pid {
+			fmt.Printf("Upgraded: new instance running with PID %d\n", p.Pid)
+			return
+		}
+	}
+	log.Error("the upgrade didn't finish in time. Check the log of web-msg-handler")
+	os.Exit(1)
+}
+
+// startArgs returns the flags of the command provided that were set, so an instance started with them
+// is like this one (i.e. when upgrading). It returns nil if cmd is nil.
+func startArgs(cmd *cobra.Command) []string {
+	if cmd == nil {
+		return nil
+	}
+	var args []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
@@ -1,11 +1,18 @@
 module github.com/Miguel-Dorta/web-msg-handler
 
 require (
+	filippo.io/age v1.0.0
 	github.com/Miguel-Dorta/logolang v0.5.1
 	github.com/Miguel-Dorta/si v0.0.0-20200206205832-c3abcfc62378
+	github.com/ProtonMail/go-crypto v0.0.0-20210428141323-04723f9f07d7
+	github.com/emersion/go-msgauth v0.6.5
 	github.com/pelletier/go-toml v1.8.0
 	github.com/spf13/cobra v0.0.5
-	golang.org/x/sys v0.0.0-20190712062909-fae7ac547cb7
+	github.com/spf13/pflag v1.0.3
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/sys v0.0.0-20210903071746-97244b99971b
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 go 1.14
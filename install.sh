@@ -3,7 +3,9 @@
 INSTALLATION_PATH="/opt/web-msg-handler"
 SETTINGS_PATH="/etc/opt/web-msg-handler"
 PLUGINS_PATH="$SETTINGS_PATH/plugins"
+PROFILES_PATH="$SETTINGS_PATH/profiles"
 SITES_PATH="$SETTINGS_PATH/sites"
+TEMPLATES_PATH="$SETTINGS_PATH/templates"
 SYSTEMD_SERVICE_PATH="/lib/systemd/system/web-msg-handler.service"
 NGINX_SITE_PATH="/etc/nginx/sites/web-msg-handler.conf"
 
@@ -16,15 +18,18 @@ fi
 # Change owner of all files to root
 chown -R root:root *
 
-# Copy program to installation path
+# Copy program to installation path (replacing it atomically, so a running instance can be upgraded)
 mkdir -p $INSTALLATION_PATH
-cp web-msg-handler $INSTALLATION_PATH
+cp web-msg-handler $INSTALLATION_PATH/.web-msg-handler.new
+mv -f $INSTALLATION_PATH/.web-msg-handler.new $INSTALLATION_PATH/web-msg-handler
 
 # Copy configs and plugins
-mkdir -p $PLUGINS_PATH $SITES_PATH
+mkdir -p $PLUGINS_PATH $PROFILES_PATH $SITES_PATH $TEMPLATES_PATH
 cp examples/config.toml $SETTINGS_PATH/config.toml.example
 cp examples/sites/mail.toml $SITES_PATH/mail.toml.example
 cp examples/sites/telegram.toml $SITES_PATH/telegram.toml.example
+cp examples/profiles/mail.toml $PROFILES_PATH/mail.toml.example
+cp examples/templates/* $TEMPLATES_PATH
 cp plugins/* $PLUGINS_PATH
 
 # Copy systemd unit
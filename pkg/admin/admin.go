@@ -0,0 +1,415 @@
+package admin
+// Package admin implements the admin HTTP API, which manages the site configs. Its endpoints are:
+//
+// - GET /sites: lists every site.
+//
+// - GET /sites/{id}: returns a site.
+//
+// - PUT /sites/{id}: creates or updates a site with the config provided as a JSON object with the keys of the TOML
+// site configs. It's validated like the site configs loaded when reloading.
+//
+// - DELETE /sites/{id}: deletes a site.
+//
+// - POST /sites/{id}/enable and POST /sites/{id}/disable: enable or disable a site, setting the key "enabled"
+// of its config.
+//
+// Every request must be authenticated with the header "Authorization: Bearer <admin_token>".
+// The site configs are written atomically, and the config is reloaded after every change. The reload is asynchronous,
+// so a successful response means that the change was validated and written, not that it's already applied.
+// The problems of the configs never contain the values of their secrets (see config.CheckError).
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/api"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/mime"
+	"github.com/pelletier/go-toml"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// pathPrefixSites is the path prefix of the site endpoints
+	pathPrefixSites = "/sites"
+
+	// ext is the extension of the site configs created by the admin API
+	ext = ".toml"
+)
+
+// regexSiteID matches the site IDs that can be managed by the admin API, since they're used as file names
+var regexSiteID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// errNotFound is returned when a site doesn't exist
+var errNotFound = errors.New("site not found")
+
+// Handler is the http.Handler of the admin API
+type Handler struct {
+	// token returns the token that authenticates the requests
+	token func() string
+
+	// reload reloads the config after a change
+	reload func()
+
+	// mutex serializes the changes to the site configs
+	mutex sync.Mutex
+}
+
+// siteInfo is the representation of a site config in the admin API
+type siteInfo struct {
+	ID      string                 `json:"id"`
+	Enabled bool                   `json:"enabled"`
+	File    string                 `json:"file"`
+	Config  map[string]interface{} `json:"config,omitempty"`
+}
+
+// NewHandler returns the handler of the admin API. token returns the token that authenticates the requests,
+// and reload is called after every change of the site configs (it can apply the change asynchronously).
+func NewHandler(token func() string, reload func()) *Handler {
+	return &Handler{token: token, reload: reload}
+}
+
+// ServeHTTP handles a request to the admin API
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authenticated(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="web-msg-handler"`)
+		writeError(w, http.StatusUnauthorized, api.CodeUnauthorized, "unauthorized")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if "/"+parts[0] != pathPrefixSites || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, api.CodeNotFound, "not found")
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		h.list(w)
+	case len(parts) == 1:
+		writeError(w, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "method not allowed")
+	case !regexSiteID.MatchString(parts[1]):
+		writeError(w, http.StatusNotFound, api.CodeNotFound, "not found")
+	case len(parts) == 3 && r.Method != http.MethodPost:
+		writeError(w, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "method not allowed")
+	case len(parts) == 3 && parts[2] == "enable":
+		h.setEnabled(w, parts[1], true)
+	case len(parts) == 3 && parts[2] == "disable":
+		h.setEnabled(w, parts[1], false)
+	case len(parts) == 3:
+		writeError(w, http.StatusNotFound, api.CodeNotFound, "not found")
+	case r.Method == http.MethodGet:
+		h.get(w, parts[1])
+	case r.Method == http.MethodPut:
+		h.put(w, r, parts[1])
+	case r.Method == http.MethodDelete:
+		h.delete(w, parts[1])
+	default:
+		writeError(w, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// authenticated returns if the request provided has the bearer token of the handler
+func (h *Handler) authenticated(r *http.Request) bool {
+	token := h.token()
+	auth := r.Header.Get("Authorization")
+	if token == "" || !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) == 1
+}
+
+// list writes every site, without their configs
+func (h *Handler) list(w http.ResponseWriter) {
+	sites, err := findSites()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, api.CodeInternalError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, sites)
+}
+
+// get writes the site with the ID provided
+func (h *Handler) get(w http.ResponseWriter, id string) {
+	site, err := findSite(id)
+	if err != nil {
+		writeFindError(w, err)
+		return
+	}
+
+	m, err := config.ReadMap(site.File)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, api.CodeInternalError, err.Error())
+		return
+	}
+	site.Config = m
+	writeJSON(w, http.StatusOK, site)
+}
+
+// put creates or updates the site with the ID provided with the config of the request body
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, id string) {
+	if contentType := r.Header.Get(mime.ContentType); !strings.Contains(contentType, mime.JSON) {
+		writeError(w, http.StatusBadRequest, api.CodeContentTypeNotAllowed, mime.ContentType+" not allowed")
+		return
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.UseNumber()
+	var m map[string]interface{}
+	if err := d.Decode(&m); err != nil {
+		writeError(w, http.StatusBadRequest, api.CodeMalformedJSON, "malformed JSON")
+		return
+	}
+
+	if bodyID, ok := m["id"]; ok && bodyID != id {
+		writeValidationError(w, "id", "id must match the site ID of the path")
+		return
+	}
+	m["id"] = id
+
+	tree, err := toml.TreeFromMap(normalize(m).(map[string]interface{}))
+	if err != nil {
+		writeValidationError(w, "", err.Error())
+		return
+	}
+	data, err := tree.Marshal()
+	if err != nil {
+		writeValidationError(w, "", err.Error())
+		return
+	}
+	if _, err := config.ParseSite(data); err != nil {
+		writeSiteError(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	site, err := findSite(id)
+	switch {
+	case errors.Is(err, errNotFound):
+		status = http.StatusCreated
+		site = &siteInfo{
+			ID:      id,
+			Enabled: true,
+			File:    filepath.Join(config.Directory, config.SitesDirectory, id+ext),
+		}
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, api.CodeInternalError, err.Error())
+		return
+	}
+
+	if data, err = config.MarshalMap(site.File, tree.ToMap()); err != nil {
+		writeError(w, http.StatusInternalServerError, api.CodeInternalError, err.Error())
+		return
+	}
+	if err := writeFileAtomic(site.File, data); err != nil {
+		writeError(w, http.StatusInternalServerError, api.CodeInternalError, err.Error())
+		return
+	}
+	h.reload()
+
+	site.Config = tree.ToMap()
+	writeJSON(w, status, site)
+}
+
+// delete deletes the site with the ID provided
+func (h *Handler) delete(w http.ResponseWriter, id string) {
+	site, err := findSite(id)
+	if err != nil {
+		writeFindError(w, err)
+		return
+	}
+
+	if err := os.Remove(site.File); err != nil {
+		writeError(w, http.StatusInternalServerError, api.CodeInternalError, err.Error())
+		return
+	}
+	h.reload()
+	writeJSON(w, http.StatusOK, api.ResponseV2{Success: true})
+}
+
+// setEnabled enables or disables the site with the ID provided, setting the key "enabled" of its config
+// (see config.SetKey, which preserves the comments of TOML configs).
+// The disabled sites reply their maintenance_message to every message.
+func (h *Handler) setEnabled(w http.ResponseWriter, id string, enabled bool) {
+	site, err := findSite(id)
+	if err != nil {
+		writeFindError(w, err)
+		return
+	}
+	if site.Enabled == enabled {
+		writeJSON(w, http.StatusOK, site)
+		return
+	}
+
+	var value interface{}
+	if !enabled {
+		value = false
+	}
+	data, err := config.SetKey(site.File, "enabled", value)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, api.CodeInternalError, err.Error())
+		return
+	}
+	if err := writeFileAtomic(site.File, data); err != nil {
+		writeError(w, http.StatusInternalServerError, api.CodeInternalError, err.Error())
+		return
+	}
+	h.reload()
+
+	site.Enabled = enabled
+	writeJSON(w, http.StatusOK, site)
+}
+
+// findSites returns every site config found in config.SitesDirectory (see config.IsConfigFile), sorted by ID.
+// Their configs are not included.
+func findSites() ([]*siteInfo, error) {
+	dir := filepath.Join(config.Directory, config.SitesDirectory)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing directory %s: %w", dir, err)
+	}
+
+	sites := make([]*siteInfo, 0, len(files))
+	for _, f := range files {
+		if !f.Mode().IsRegular() || !config.IsConfigFile(f.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		m, err := config.ReadMap(path)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing file %s: %w", path, err)
+		}
+		id, _ := m["id"].(string)
+		enabled, ok := m["enabled"].(bool)
+		sites = append(sites, &siteInfo{ID: id, Enabled: enabled || !ok, File: path})
+	}
+
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].ID < sites[j].ID
+	})
+	return sites, nil
+}
+
+// findSite returns the site with the ID provided, or errNotFound if it doesn't exist
+func findSite(id string) (*siteInfo, error) {
+	sites, err := findSites()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sites {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+// writeFileAtomic writes the data provided in a temporary file in config.Directory and renames it to the path provided,
+// so the site configs are never read half-written.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(config.Directory, ".site-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error writing temporary file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error syncing temporary file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error renaming temporary file: %w", err)
+	}
+	return nil
+}
+
+// normalize converts the JSON numbers of the value provided to int64 or float64, so they can be encoded in TOML
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalize(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+	}
+	return v
+}
+
+// writeFindError writes the error returned by findSite
+func writeFindError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, api.CodeNotFound, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, api.CodeInternalError, err.Error())
+}
+
+// writeValidationError writes a validation error of the field provided (none if empty)
+func writeValidationError(w http.ResponseWriter, field, msg string) {
+	resp := api.ResponseV2{Code: api.CodeValidationFailed, Err: msg}
+	if field != "" {
+		resp.Fields = []api.FieldError{{Field: field, Code: api.CodeValidationFailed, Err: msg}}
+	}
+	writeJSON(w, http.StatusBadRequest, resp)
+}
+
+// writeSiteError writes the error returned when parsing a site config. The problems of a *config.CheckError
+// are written as errors of their keys.
+func writeSiteError(w http.ResponseWriter, err error) {
+	var checkErr *config.CheckError
+	if !errors.As(err, &checkErr) {
+		writeValidationError(w, "", err.Error())
+		return
+	}
+
+	resp := api.ResponseV2{Code: api.CodeValidationFailed, Err: "invalid site config"}
+	for _, p := range checkErr.Problems {
+		resp.Fields = append(resp.Fields, api.FieldError{Field: p.Key, Code: api.CodeValidationFailed, Err: p.Err.Error()})
+	}
+	writeJSON(w, http.StatusBadRequest, resp)
+}
+
+// writeError writes an error with the status, code and message provided
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, api.ResponseV2{Code: code, Err: msg})
+}
+
+// writeJSON writes the value provided as JSON with the status provided
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, _ := json.Marshal(v)
+	w.Header().Set(mime.ContentType, mime.JSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(data, '\n'))
+}
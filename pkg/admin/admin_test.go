@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	config.Directory = dir
+	if err := os.Mkdir(filepath.Join(dir, config.SitesDirectory), 0755); err != nil {
+		t.Fatalf("error creating sites directory: %s", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, config.PluginsDirectory), 0755); err != nil {
+		t.Fatalf("error creating plugins directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, config.PluginsDirectory, "none"+config.PluginExt), nil, 0644); err != nil {
+		t.Fatalf("error writing plugin: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("file-topsecret"), 0600); err != nil {
+		t.Fatalf("error writing secret: %s", err)
+	}
+	os.Setenv("WMH_ADMIN_TEST_SECRET", "env-topsecret")
+	defer os.Unsetenv("WMH_ADMIN_TEST_SECRET")
+
+	reloads := 0
+	h := NewHandler(func() string { return "secret" }, func() { reloads++ })
+
+	tests := []struct {
+		method, path, token, body string
+		status                    int
+		expected                  string
+	}{
+		{http.MethodGet, "/sites", "wrong", "", http.StatusUnauthorized, `"code":"UNAUTHORIZED"`},
+		{http.MethodPut, "/sites/a", "secret", `{"sender_type":"none","web_url":"https://a.com","sender":{"port":587}}`,
+			http.StatusCreated, `"config":{"id":"a","sender":{"port":587},"sender_type":"none","web_url":"https://a.com"}`},
+		{http.MethodPut, "/sites/a", "secret", `{"web_url":1}`, http.StatusBadRequest, `"code":"VALIDATION_FAILED"`},
+		{http.MethodPut, "/sites/a", "secret", `{"id":"b"}`, http.StatusBadRequest, `"field":"id"`},
+		{http.MethodPut, "/sites/a", "secret", `{"sender_type":"none","web_url":"${env:WMH_ADMIN_TEST_SECRET}"}`,
+			http.StatusBadRequest, `invalid web_url ${env:WMH_ADMIN_TEST_SECRET}`},
+		{http.MethodPut, "/sites/a", "secret", `{"sender_type":"${file:token}"}`,
+			http.StatusBadRequest, `invalid sender_type ${file:token}`},
+		{http.MethodPut, "/sites/..", "secret", `{}`, http.StatusNotFound, `"code":"NOT_FOUND"`},
+		{http.MethodPut, "/sites/b", "secret", `{"sender_type":"none"`, http.StatusBadRequest, `"code":"MALFORMED_JSON"`},
+		{http.MethodPost, "/sites/a/disable", "secret", "", http.StatusOK, `"enabled":false`},
+		{http.MethodGet, "/sites", "secret", "", http.StatusOK, `[{"id":"a","enabled":false,"file":"` +
+			filepath.Join(dir, config.SitesDirectory, "a.toml") + `"}]`},
+		{http.MethodPost, "/sites/a/enable", "secret", "", http.StatusOK, `"enabled":true`},
+		{http.MethodDelete, "/sites/a", "secret", "", http.StatusOK, `{"success":true}`},
+		{http.MethodGet, "/sites/a", "secret", "", http.StatusNotFound, `"code":"NOT_FOUND"`},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if strings.Contains(w.Body.String(), "topsecret") {
+			t.Errorf("secret revealed by %s %s: %s", test.method, test.path, w.Body.String())
+		}
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.expected) {
+			t.Errorf("Unexpected response of %s %s:\n-> Expected: %d %s\n-> Found: %d %s",
+				test.method, test.path, test.status, test.expected, w.Code, w.Body.String())
+		}
+	}
+
+	if reloads != 4 {
+		t.Errorf("unexpected number of reloads: %d", reloads)
+	}
+	if _, err := config.LoadSites(); err != nil {
+		t.Errorf("error loading sites: %s", err)
+	}
+}
+
+func TestSetEnabledPreservesComments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	config.Directory = dir
+	for _, d := range []string{config.SitesDirectory, config.PluginsDirectory} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatalf("error creating directory %s: %s", d, err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, config.PluginsDirectory, "none"+config.PluginExt), nil, 0644); err != nil {
+		t.Fatalf("error writing plugin: %s", err)
+	}
+
+	const original = `# Site of a
+sender_type="none" # no sender
+id="a"
+
+[sender]
+# Port of the server
+port=587
+`
+	path := filepath.Join(dir, config.SitesDirectory, "a.toml")
+	if err := ioutil.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatalf("error writing site config: %s", err)
+	}
+
+	h := NewHandler(func() string { return "secret" }, func() {})
+	for _, test := range []struct {
+		action, expected string
+	}{
+		{"disable", strings.Replace(original, "id=\"a\"\n", "id=\"a\"\nenabled=false\n", 1)},
+		{"enable", original},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/sites/a/"+test.action, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected response of %s: %d %s", test.action, w.Code, w.Body.String())
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("error reading site config: %s", err)
+		}
+		if string(data) != test.expected {
+			t.Errorf("unexpected site config after %s:\n-> Expected: %s\n-> Found: %s", test.action, test.expected, data)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package archive
+// Package archive records the messages accepted by web-msg-handler in an embedded database saved in config.Directory.
+// The database is opened only while it's being used, so it can be read by other processes while the server runs.
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Filename is the name of the archive database in config.Directory
+	Filename = "archive.db"
+
+	// Outcomes of the records
+	OutcomeSuccess   = "success"
+	OutcomeFailed    = "failed"
+	OutcomeTimeout   = "timeout"
+	OutcomeAbandoned = "abandoned"
+
+	// openTimeout is the maximum time to wait for the lock of the database
+	openTimeout = 5 * time.Second
+)
+
+// ErrNotFound is returned when a record doesn't exist
+var ErrNotFound = errors.New("message not found")
+
+var (
+	// bucketMessages is the bucket where the records are saved, indexed by their ID in big endian
+	bucketMessages = []byte("messages")
+
+	// mutex serializes the access to the database from this process, since its lock is per file descriptor
+	mutex sync.Mutex
+)
+
+// Record is a message accepted by web-msg-handler
+type Record struct {
+	ID          uint64    `json:"id"`
+	Time        time.Time `json:"time"`
+	SiteID      string    `json:"site_id"`
+	Name        string    `json:"name"`
+	Mail        string    `json:"mail"`
+	Msg         string    `json:"msg"`
+	ClientIP    string    `json:"client_ip"`
+	RequestID   string    `json:"request_id"`
+	Outcome     string    `json:"outcome"`
+	SenderError string    `json:"sender_error,omitempty"`
+
+	// RetentionDays are the retention days of the site when the record was saved (0 if it's kept forever).
+	// They're used to expire it if the site is deleted.
+	RetentionDays int `json:"retention_days,omitempty"`
+}
+
+// Filter selects the records that match all its fields. Empty fields match any record.
+type Filter struct {
+	// SiteID is the ID of the site of the records
+	SiteID string
+
+	// Mail is the mail of the submitter of the records (case-insensitive)
+	Mail string
+
+	// Since and Before are the time range of the records (Since included)
+	Since, Before time.Time
+
+	// Expired is a time at which the records must be past their own RetentionDays (records without them never expire)
+	Expired time.Time
+}
+
+// Path returns the path of the archive database
+func Path() string {
+	return filepath.Join(config.Directory, Filename)
+}
+
+// Save saves the record provided, assigning it a new ID
+func Save(r *Record) error {
+	return update(func(b *bbolt.Bucket) error {
+		id, err := b.NextSequence()
+		if err != nil {
+			return fmt.Errorf("error generating message ID: %w", err)
+		}
+		r.ID = id
+
+		data, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("error serializing message: %w", err)
+		}
+		return b.Put(key(id), data)
+	})
+}
+
+// List returns the records that match the filter provided, sorted by ID
+func List(f Filter) ([]*Record, error) {
+	var records []*Record
+	err := view(func(b *bbolt.Bucket) error {
+		return b.ForEach(func(_, v []byte) error {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("error parsing message: %w", err)
+			}
+			if f.matches(&r) {
+				records = append(records, &r)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+// Get returns the record with the ID provided
+func Get(id uint64) (*Record, error) {
+	var r *Record
+	err := view(func(b *bbolt.Bucket) error {
+		v := b.Get(key(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		r = new(Record)
+		if err := json.Unmarshal(v, r); err != nil {
+			return fmt.Errorf("error parsing message: %w", err)
+		}
+		return nil
+	})
+	if r == nil && err == nil {
+		err = ErrNotFound
+	}
+	return r, err
+}
+
+// Delete deletes the records that match the filter provided and returns how many were deleted
+func Delete(f Filter) (int, error) {
+	if _, err := os.Stat(Path()); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	var keys [][]byte
+	err := update(func(b *bbolt.Bucket) error {
+		err := b.ForEach(func(k, v []byte) error {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("error parsing message: %w", err)
+			}
+			if f.matches(&r) {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return fmt.Errorf("error deleting message: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// matches returns if the record provided matches the filter
+func (f Filter) matches(r *Record) bool {
+	return (f.SiteID == "" || r.SiteID == f.SiteID) &&
+		(f.Mail == "" || strings.EqualFold(r.Mail, f.Mail)) &&
+		!r.Time.Before(f.Since) &&
+		(f.Before.IsZero() || r.Time.Before(f.Before)) &&
+		(f.Expired.IsZero() || r.RetentionDays != 0 && r.Time.AddDate(0, 0, r.RetentionDays).Before(f.Expired))
+}
+
+// update executes fn in a read-write transaction of the messages bucket, creating the database if needed
+func update(fn func(b *bbolt.Bucket) error) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	db, err := bbolt.Open(Path(), 0600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return fmt.Errorf("error opening archive: %w", err)
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketMessages)
+		if err != nil {
+			return fmt.Errorf("error creating archive bucket: %w", err)
+		}
+		return fn(b)
+	})
+}
+
+// view executes fn in a read-only transaction of the messages bucket.
+// fn is not executed if the database or the bucket don't exist.
+func view(fn func(b *bbolt.Bucket) error) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, err := os.Stat(Path()); os.IsNotExist(err) {
+		return nil
+	}
+
+	db, err := bbolt.Open(Path(), 0600, &bbolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("error opening archive: %w", err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketMessages)
+		if b == nil {
+			return nil
+		}
+		return fn(b)
+	})
+}
+
+// key returns the key of the ID provided
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
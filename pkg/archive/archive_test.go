@@ -0,0 +1,58 @@
+package archive
+
+import (
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	config.Directory = dir
+
+	if records, err := List(Filter{}); err != nil || len(records) != 0 {
+		t.Fatalf("unexpected records of missing archive: %v (%v)", records, err)
+	}
+
+	now := time.Now()
+	for _, r := range []*Record{
+		{Time: now.Add(-48 * time.Hour), SiteID: "a", Mail: "old@example.com", Outcome: OutcomeSuccess},
+		{Time: now, SiteID: "a", Mail: "new@example.com", Outcome: OutcomeFailed, SenderError: "exit status 1"},
+		{Time: now, SiteID: "b", Mail: "other@example.com", Outcome: OutcomeSuccess},
+	} {
+		if err := Save(r); err != nil {
+			t.Fatalf("error saving record: %s", err)
+		}
+	}
+
+	records, err := List(Filter{SiteID: "a", Since: now.Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("error listing records: %s", err)
+	}
+	if len(records) != 1 || records[0].ID != 2 || records[0].SenderError != "exit status 1" {
+		t.Errorf("unexpected records: %+v", records)
+	}
+
+	if r, err := Get(3); err != nil || r.Mail != "other@example.com" {
+		t.Errorf("unexpected record 3: %+v (%v)", r, err)
+	}
+	if _, err := Get(4); err != ErrNotFound {
+		t.Errorf("unexpected error getting missing record: %v", err)
+	}
+
+	if n, err := Delete(Filter{SiteID: "a", Before: now.Add(-time.Hour)}); err != nil || n != 1 {
+		t.Errorf("unexpected result deleting expired records: %d (%v)", n, err)
+	}
+	if n, err := Delete(Filter{Mail: "Other@Example.com"}); err != nil || n != 1 {
+		t.Errorf("unexpected result deleting records by mail: %d (%v)", n, err)
+	}
+	if records, err := List(Filter{}); err != nil || len(records) != 1 || records[0].ID != 2 {
+		t.Errorf("unexpected records after deleting: %+v (%v)", records, err)
+	}
+}
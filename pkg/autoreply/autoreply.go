@@ -0,0 +1,126 @@
+package autoreply
+// Package autoreply sends the confirmation emails to the visitors whose messages were delivered.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/mail"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/templates"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// cleanupThreshold is the number of throttled addresses that triggers the removal of the expired ones
+const cleanupThreshold = 1000
+
+// ErrThrottled is returned by Send when the site sent an auto-reply to the same address recently
+var ErrThrottled = errors.New("auto-reply to this address throttled")
+
+var (
+	// throttled are the addresses that won't receive an auto-reply until the time they point to,
+	// keyed by the ID of the site and the address (see throttleKey)
+	throttled = make(map[string]time.Time)
+	mutex     sync.Mutex
+)
+
+// Data is the data available in the templates of the auto-replies
+type Data struct {
+	// Name, Mail and Msg are the fields of the message sent by the visitor
+	Name, Mail, Msg string
+
+	// Website is the name of the site, which will be the from_name of its autoreply config or its ID if not defined
+	Website string
+}
+
+// Send sends the auto-reply of the site provided to the visitor described by the data provided.
+// It returns ErrThrottled without sending anything if the site sent an auto-reply to the same address
+// less than its throttle ago. The address is only throttled if the auto-reply is sent.
+func Send(site *config.Site, d Data) (err error) {
+	ar := site.AutoReply
+	if d.Website == "" {
+		d.Website = ar.FromName
+		if d.Website == "" {
+			d.Website = site.ID
+		}
+	}
+
+	key := throttleKey(site.ID, d.Mail)
+	if !allow(key, time.Duration(ar.Throttle)*time.Second) {
+		return ErrThrottled
+	}
+	defer func() {
+		if err != nil {
+			release(key)
+		}
+	}()
+
+	subject, err := render(ar.Subject, d)
+	if err != nil {
+		return fmt.Errorf("error rendering subject: %w", err)
+	}
+	body, err := render(ar.Body, d)
+	if err != nil {
+		return fmt.Errorf("error rendering body: %w", err)
+	}
+
+	return mail.Send(ar.SMTP(), ar.From, &mail.Message{
+		From:    mail.Address{Name: ar.FromName, Address: ar.From},
+		To:      []mail.Address{{Name: d.Name, Address: d.Mail}},
+		Subject: subject,
+		Text:    body,
+	})
+}
+
+// throttleKey returns the key of the address provided for the site provided in throttled.
+// Addresses are case-insensitive.
+func throttleKey(siteID, addr string) string {
+	return siteID + "\x00" + strings.ToLower(addr)
+}
+
+// allow returns if an auto-reply can be sent for the key provided (see throttleKey), and throttles it
+// for the interval provided if so. The throttle must be released if the auto-reply is not sent (see release).
+func allow(key string, interval time.Duration) bool {
+	now := time.Now()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if until, ok := throttled[key]; ok && now.Before(until) {
+		return false
+	}
+
+	if len(throttled) >= cleanupThreshold {
+		for a, until := range throttled {
+			if !now.Before(until) {
+				delete(throttled, a)
+			}
+		}
+	}
+	throttled[key] = now.Add(interval)
+	return true
+}
+
+// release removes the throttle of the key provided, so the auto-reply that could not be sent can be retried
+func release(key string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(throttled, key)
+}
+
+// render executes the template provided with the data provided
+func render(tmpl string, d Data) (string, error) {
+	t, err := template.New("").Funcs(templates.Funcs).Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, d); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,50 @@
+package autoreply
+
+import (
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	if !allow(throttleKey("a", "visitor@example.com"), time.Hour) {
+		t.Fatal("first auto-reply was throttled")
+	}
+	if allow(throttleKey("a", "Visitor@Example.com"), time.Hour) {
+		t.Error("second auto-reply to the same address was allowed")
+	}
+	if !allow(throttleKey("b", "visitor@example.com"), time.Hour) {
+		t.Error("auto-reply of other site to the same address was throttled")
+	}
+	if !allow(throttleKey("a", "other@example.com"), 0) || !allow(throttleKey("a", "other@example.com"), 0) {
+		t.Error("auto-reply with expired throttle was not allowed")
+	}
+}
+
+func TestSendFailureNotThrottled(t *testing.T) {
+	site := &config.Site{ID: "failure", AutoReply: &config.AutoReply{
+		From:     "site@example.com",
+		Subject:  "{{",
+		Body:     config.DefaultAutoReplyBody,
+		Throttle: 3600,
+	}}
+	for i := 0; i < 2; i++ {
+		if err := Send(site, Data{Mail: "visitor@example.com"}); err == nil || err == ErrThrottled {
+			t.Errorf("unexpected error of attempt %d: %v", i, err)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	s, err := render(config.DefaultAutoReplyBody, Data{Name: "John", Msg: "Hi!", Website: "example.com"})
+	if err != nil {
+		t.Fatalf("error rendering default body: %s", err)
+	}
+
+	expected := "Hello John,\n\nThank you for contacting example.com. " +
+		"We have received your message and we will reply as soon as possible.\n\n" +
+		"This is a copy of your message:\n\nHi!\n"
+	if s != expected {
+		t.Errorf("Unexpected body:\n-> Expected: %q\n-> Found: %q", expected, s)
+	}
+}
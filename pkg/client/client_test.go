@@ -7,6 +7,7 @@ import (
 	"github.com/Miguel-Dorta/web-msg-handler/pkg/mime"
 	"golang.org/x/sys/unix"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -60,8 +61,13 @@ func TestPostJSON(t *testing.T) {
 		end <- true //Send end status
 	}()
 
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+
 	go func() {
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
 			t.Errorf("Unexpected error which closed the server: %s", err)
 		}
 	}()
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/mail"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/sanitation"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/templates"
+	"text/template"
+)
+
+const (
+	// DefaultAutoReplyThrottle is the default minimum time in seconds between two auto-replies to the same address
+	DefaultAutoReplyThrottle = 3600
+
+	// DefaultAutoReplySubject is the default template of the subject of the auto-replies
+	DefaultAutoReplySubject = "We have received your message"
+
+	// DefaultAutoReplyBody is the default template of the body of the auto-replies
+	DefaultAutoReplyBody = `Hello {{.Name}},
+
+Thank you for contacting {{.Website}}. We have received your message and we will reply as soon as possible.
+
+This is a copy of your message:
+
+{{.Msg}}
+`
+)
+
+var (
+	// ErrAutoReplyNoHostname is returned when an autoreply config doesn't have a hostname
+	ErrAutoReplyNoHostname = errors.New("invalid autoreply: hostname required")
+
+	// ErrAutoReplyInvalidFrom is returned when an autoreply config doesn't have a valid from address
+	ErrAutoReplyInvalidFrom = errors.New("invalid autoreply: from must be a valid email")
+
+	// ErrAutoReplyInvalidThrottle is returned when an autoreply config have a negative throttle
+	ErrAutoReplyInvalidThrottle = errors.New("invalid autoreply: throttle must not be negative")
+)
+
+// AutoReply is the config of the confirmation email sent to the visitors after delivering their message.
+// Subject and Body are text/template templates (see package autoreply for the data available)
+// with the helpers of templates.Funcs.
+type AutoReply struct {
+	Hostname string `toml:"hostname"`
+	Port     int    `toml:"port"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	From     string `toml:"from"`
+	FromName string `toml:"from_name"`
+	Subject  string `toml:"subject"`
+	Body     string `toml:"body"`
+	Throttle int    `toml:"throttle"`
+}
+
+// SMTP returns the config of the SMTP server used for sending the auto-replies
+func (ar *AutoReply) SMTP() *mail.SMTP {
+	return &mail.SMTP{
+		Hostname: ar.Hostname,
+		Port:     ar.Port,
+		Username: ar.Username,
+		Password: ar.Password,
+	}
+}
+
+// check checks that the config is valid and sets the default values of the settings not defined
+func (ar *AutoReply) check() error {
+	if ar.Hostname == "" {
+		return ErrAutoReplyNoHostname
+	}
+	if !sanitation.IsValidMail(ar.From) {
+		return ErrAutoReplyInvalidFrom
+	}
+
+	switch {
+	case ar.Throttle < 0:
+		return ErrAutoReplyInvalidThrottle
+	case ar.Throttle == 0:
+		ar.Throttle = DefaultAutoReplyThrottle
+	}
+
+	if ar.Port == 0 {
+		ar.Port = 465
+	}
+	if ar.Subject == "" {
+		ar.Subject = DefaultAutoReplySubject
+	}
+	if ar.Body == "" {
+		ar.Body = DefaultAutoReplyBody
+	}
+
+	if _, err := template.New("subject").Funcs(templates.Funcs).Parse(ar.Subject); err != nil {
+		return fmt.Errorf("invalid autoreply subject: %w", err)
+	}
+	if _, err := template.New("body").Funcs(templates.Funcs).Parse(ar.Body); err != nil {
+		return fmt.Errorf("invalid autoreply body: %w", err)
+	}
+	return nil
+}
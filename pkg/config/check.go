@@ -0,0 +1,223 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// regexPosition matches the position that prefixes the errors of go-toml, like "(3, 1): expecting a value"
+var regexPosition = regexp.MustCompile(`^\((\d+), \d+\): `)
+
+// Problem is a problem found in a config file, in the Line where its Key is defined.
+// Line is 0 and Key is empty if it's not related to a specific key.
+type Problem struct {
+	File, Key string
+	Line      int
+	Err       error
+}
+
+// String returns the problem as "file:line: error"
+func (p Problem) String() string {
+	switch {
+	case p.File == "" && p.Line == 0:
+		return p.Err.Error()
+	case p.File == "":
+		return fmt.Sprintf("line %d: %s", p.Line, p.Err)
+	case p.Line == 0:
+		return p.File + ": " + p.Err.Error()
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Err)
+}
+
+// CheckError is returned when the configs loaded have problems. It contains every problem found, sorted by file and line.
+// The values of the secrets resolved are replaced with their references in the problems (see resolveSecrets).
+type CheckError struct {
+	Problems []Problem
+}
+
+// Error returns every problem separated by semicolons
+func (e *CheckError) Error() string {
+	msgs := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		msgs = append(msgs, p.String())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the error of the problem if there is only one, so it can be checked with errors.Is
+func (e *CheckError) Unwrap() error {
+	if len(e.Problems) != 1 {
+		return nil
+	}
+	return e.Problems[0].Err
+}
+
+// checker collects the problems of a config file
+type checker struct {
+	file     string
+	tree     *toml.Tree
+	problems []Problem
+
+	// lines are the lines of every key of tree, since go-toml loses them when a value is replaced
+	lines map[string]int
+
+	// files are the files of the keys merged from other files (see mergeProfile)
+	files map[string]string
+
+	// secrets are the values of the secrets resolved (see resolveSecrets) with the references that defined them,
+	// so they can be redacted from the problems
+	secrets map[string]string
+}
+
+// newChecker parses the config file provided (see load). If it's not valid, the problem is collected and tree is nil.
+// The references to secrets are not resolved until resolveSecrets is called.
+func newChecker(file string, data []byte) *checker {
+	ch := &checker{
+		file:    file,
+		lines:   make(map[string]int),
+		files:   make(map[string]string),
+		secrets: make(map[string]string),
+	}
+	ch.tree = ch.load(file, data)
+	return ch
+}
+
+// load parses the config file provided, in the format of its extension (TOML if unknown),
+// and saves the lines of its keys (unless they're already saved).
+// If it's not valid, the problem is collected and nil is returned.
+func (ch *checker) load(file string, data []byte) *toml.Tree {
+	if tree, ok := ch.loadMap(file, data); ok {
+		return tree
+	}
+
+	tree, err := toml.LoadBytes(data)
+	if err != nil {
+		ch.problems = append(ch.problems, tomlProblem(file, err))
+		return nil
+	}
+	ch.saveLines(file, tree, "")
+	return tree
+}
+
+// saveLines saves the lines of every key of the tree provided, defined in the file provided,
+// unless they're already saved. prefix is the path of the tree.
+func (ch *checker) saveLines(file string, tree *toml.Tree, prefix string) {
+	for _, k := range tree.Keys() {
+		if _, saved := ch.lines[prefix+k]; !saved {
+			ch.lines[prefix+k] = tree.GetPosition(k).Line
+			if file != ch.file {
+				ch.files[prefix+k] = file
+			}
+		}
+		if sub, ok := tree.Get(k).(*toml.Tree); ok {
+			ch.saveLines(file, sub, prefix+k+".")
+		}
+	}
+}
+
+// unmarshal unmarshals the config into the value provided, and checks that every key is known by it.
+// It returns false if the config cannot be unmarshalled.
+func (ch *checker) unmarshal(v interface{}) bool {
+	if err := ch.tree.Unmarshal(v); err != nil {
+		ch.addErr(err)
+		return false
+	}
+	ch.checkKeys(ch.tree, reflect.TypeOf(v), "")
+	return true
+}
+
+// checkKeys collects a problem for each key of the tree provided that has no field in the type provided.
+// The tables are checked recursively if their field is a struct. prefix is the path of the tree.
+func (ch *checker) checkKeys(tree *toml.Tree, t reflect.Type, prefix string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	for _, k := range tree.Keys() {
+		path := prefix + k
+		field, ok := fieldByKey(t, k)
+		if !ok {
+			ch.add(path, fmt.Errorf("unknown key %s", path))
+			continue
+		}
+
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sub, ok := tree.Get(k).(*toml.Tree); ok && ft.Kind() == reflect.Struct {
+			ch.checkKeys(sub, ft, path+".")
+		}
+	}
+}
+
+// fieldByKey returns the field of the struct type provided whose TOML key is the one provided
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if tag := strings.Split(f.Tag.Get("toml"), ",")[0]; tag == key {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// line returns the line of the key provided, or 0 if it's not defined
+func (ch *checker) line(key string) int {
+	return ch.lines[key]
+}
+
+// add collects the error provided in the file and line of the key provided (if any)
+func (ch *checker) add(key string, err error) {
+	file, ok := ch.files[key]
+	if !ok {
+		file = ch.file
+	}
+	ch.problems = append(ch.problems, Problem{File: file, Key: key, Line: ch.line(key), Err: ch.redact(err)})
+}
+
+// addErr collects an error of go-toml
+func (ch *checker) addErr(err error) {
+	ch.problems = append(ch.problems, tomlProblem(ch.file, ch.redact(err)))
+}
+
+// tomlProblem returns the problem of an error of go-toml in the file provided, taking the line from its message
+func tomlProblem(file string, err error) Problem {
+	p := Problem{File: file, Err: err}
+	if m := regexPosition.FindStringSubmatch(err.Error()); m != nil {
+		p.Line, _ = strconv.Atoi(m[1])
+		p.Err = errors.New(err.Error()[len(m[0]):])
+	}
+	return p
+}
+
+// newCheckError returns a *CheckError with the problems provided sorted and without duplicates
+// (i.e. the ones of a profile used by several sites), or nil if there are none
+func newCheckError(problems []Problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return problems[i].File < problems[j].File
+		}
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].String() < problems[j].String()
+	})
+
+	unique := problems[:0]
+	for i, p := range problems {
+		if i == 0 || p.String() != problems[i-1].String() {
+			unique = append(unique, p)
+		}
+	}
+	return &CheckError{Problems: unique}
+}
@@ -0,0 +1,292 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes in the directory provided the files provided, where the key is their path relative to it
+// and the value is their content. Their parent directories are created if they don't exist.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("error creating directory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("error writing file %s: %s", path, err)
+		}
+	}
+}
+
+func TestLoadSitesProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	Directory = dir
+
+	writeFiles(t, dir, map[string]string{
+		filepath.Join(PluginsDirectory, "none"+PluginExt): "",
+		filepath.Join(SitesDirectory, "a.toml"):           "id=\"a\"\nsender_type=\"none\"\n",
+		filepath.Join(SitesDirectory, "b.toml"): "sender_type=\"unknown\"\nweb_url=\"https://b.com/path\"\n" +
+			"\n[templates]\ntxt=\"a.tmpl\"\n",
+		filepath.Join(SitesDirectory, "c.toml"): "\nid=\"a\"\nsender_type=\"none\"\n",
+		filepath.Join(SitesDirectory, "d.toml"): "id=\"d\"\nsender_type=]\n",
+	})
+
+	expected := []struct {
+		file string
+		line int
+	}{
+		{"b.toml", 0}, // id required
+		{"b.toml", 1}, // plugin not found
+		{"b.toml", 2}, // invalid web_url
+		{"b.toml", 5}, // unknown key templates.txt
+		{"c.toml", 2}, // duplicate ID
+		{"d.toml", 2}, // invalid TOML
+	}
+
+	_, err = LoadSites()
+	var checkErr *CheckError
+	if !errors.As(err, &checkErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(checkErr.Problems) != len(expected) {
+		t.Fatalf("unexpected problems: %s", err)
+	}
+	for i, p := range checkErr.Problems {
+		if filepath.Base(p.File) != expected[i].file || p.Line != expected[i].line {
+			t.Errorf("unexpected problem: %s\n-> Expected %s:%d", p, expected[i].file, expected[i].line)
+		}
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	Directory = dir
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("file-secret\n"), 0600); err != nil {
+		t.Fatalf("error writing secret: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cred"), []byte("cred-secret"), 0600); err != nil {
+		t.Fatalf("error writing secret: %s", err)
+	}
+	os.Setenv("WMH_TEST_SECRET", "env-secret")
+	os.Setenv(CredentialsDirectoryEnv, dir)
+	defer os.Unsetenv("WMH_TEST_SECRET")
+	defer os.Unsetenv(CredentialsDirectoryEnv)
+
+	ch := newChecker("test.toml", []byte(`
+a="${env:WMH_TEST_SECRET}"
+b="Bearer ${file:token}"
+[c]
+d=["${cred:cred}", "plain"]
+e="${env:WMH_TEST_UNDEFINED}"
+`))
+	ch.resolveSecrets(ch.tree, "")
+	expected := map[string]interface{}{
+		"a": "env-secret",
+		"b": "Bearer file-secret",
+		"c": map[string]interface{}{
+			"d": []interface{}{"cred-secret", "plain"},
+			"e": "${env:WMH_TEST_UNDEFINED}",
+		},
+	}
+	if found := ch.tree.ToMap(); !reflect.DeepEqual(found, expected) {
+		t.Errorf("unexpected config:\n-> Expected: %v\n-> Found: %v", expected, found)
+	}
+	if len(ch.problems) != 1 || ch.problems[0].Line != 6 || ch.problems[0].Key != "c.e" {
+		t.Errorf("unexpected problems: %v", ch.problems)
+	}
+}
+
+func TestSecretsRedacted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	Directory = dir
+	writeFiles(t, dir, map[string]string{"token": "file-topsecret\n"})
+	os.Setenv("WMH_TEST_SECRET", "env-topsecret")
+	defer os.Unsetenv("WMH_TEST_SECRET")
+
+	for _, data := range []string{
+		"id=\"a\"\nsender_type=\"none\"\nweb_url=\"${env:WMH_TEST_SECRET}\"\n",
+		"id=\"a\"\nsender_type=\"${file:token}\"\n",
+		"id=\"a\"\nsender_type=\"none\"\nretention_days=\"${env:WMH_TEST_SECRET}\"\n",
+	} {
+		_, err := ParseSite([]byte(data))
+		var checkErr *CheckError
+		if !errors.As(err, &checkErr) {
+			t.Errorf("unexpected error parsing %q: %v", data, err)
+			continue
+		}
+		if strings.Contains(checkErr.Error(), "topsecret") {
+			t.Errorf("secret not redacted from the problems of %q: %s", data, checkErr)
+		}
+	}
+
+	_, err = parse("config.toml", []byte("port=\"${env:WMH_TEST_SECRET}\"\n"), true)
+	if err == nil || strings.Contains(err.Error(), "topsecret") {
+		t.Errorf("secret not redacted from the problems of the config: %v", err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	Directory = dir
+
+	writeFiles(t, dir, map[string]string{
+		filepath.Join(PluginsDirectory, "none"+PluginExt): "",
+		filepath.Join(ProfilesDirectory, "default.toml"):  "sender_type=\"none\"\n[sender]\nhostname=\"default\"\nport=465\n",
+		filepath.Join(ProfilesDirectory, "smtp.toml"):     "web_url=\"https://a.com\"\n[sender]\nport=587\n",
+		filepath.Join(ProfilesDirectory, "bad.toml"):      "\nid=\"bad\"\n",
+		filepath.Join(SitesDirectory, "a.toml"):           "id=\"a\"\nprofile=\"smtp\"\n[sender]\nhostname=\"a\"\n",
+		filepath.Join(SitesDirectory, "b.toml"):           "id=\"b\"\nsender_type=\"none\"\n",
+	})
+
+	sites, err := LoadSites()
+	if err != nil {
+		t.Fatalf("error loading sites: %s", err)
+	}
+	if a := sites["a"]; a.SenderName != "none" || a.WebUrls[0] != "https://a.com" ||
+		a.ConfigJSON != `{"hostname":"a","port":587}` {
+		t.Errorf("unexpected site a: %+v", a)
+	}
+	if b := sites["b"]; b.WebUrls[0] != AnyOrigin || b.ConfigJSON != `{"hostname":"default","port":465}` {
+		t.Errorf("unexpected site b: %+v", b)
+	}
+
+	for data, line := range map[string]int{
+		"id=\"c\"\nprofile=\"unknown\"\n": 2,
+		"id=\"c\"\nprofile=\"bad\"\n":     2,
+	} {
+		_, err := ParseSite([]byte(data))
+		var checkErr *CheckError
+		if !errors.As(err, &checkErr) || len(checkErr.Problems) != 1 || checkErr.Problems[0].Line != line {
+			t.Errorf("unexpected error parsing %q: %v", data, err)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	Directory = dir
+
+	writeFiles(t, dir, map[string]string{
+		filepath.Join(PluginsDirectory, "none"+PluginExt): "",
+		filepath.Join(SitesDirectory, "a.toml"):           "id=\"a\"\nsender_type=\"none\"\nweb_url=[\"https://a.com\"]\n[sender]\nport=1\n",
+		filepath.Join(SitesDirectory, "b.yaml"):           "id: b\nsender_type: none\nweb_url: [\"https://a.com\"]\nsender:\n  port: 1\n",
+		filepath.Join(SitesDirectory, "c.json"): "{\n  \"id\": \"c\",\n  \"sender_type\": \"none\",\n" +
+			"  \"web_url\": [\"https://a.com\"],\n  \"sender\": {\"port\": 1}\n}\n",
+		filepath.Join(SitesDirectory, "d.toml.example"): "invalid",
+		filepath.Join(SitesDirectory, "d.toml~"):        "invalid",
+		filepath.Join(SitesDirectory, ".d.toml.swp"):    "invalid",
+	})
+
+	sites, err := LoadSites()
+	if err != nil {
+		t.Fatalf("error loading sites: %s", err)
+	}
+	if len(sites) != 3 {
+		t.Fatalf("unexpected sites: %v", sites)
+	}
+	for _, s := range sites {
+		if s.WebUrls[0] != "https://a.com" || s.ConfigJSON != `{"port":1}` {
+			t.Errorf("unexpected site %s: %+v", s.ID, s)
+		}
+	}
+
+	for file, expected := range map[string]Problem{
+		"e.yaml": {Line: 3, Key: "autoreply.prot"},
+		"e.json": {Line: 3, Key: "autoreply.prot"},
+		"f.yaml": {Line: 2},
+		"f.json": {Line: 3},
+		"g.yaml": {Line: 1, Key: "web_url"},
+	} {
+		content := map[string]string{
+			"e.yaml": "id: e\nautoreply:\n  prot: 1\n",
+			"e.json": "{\"id\": \"e\",\n \"autoreply\": {\n  \"prot\": 1}}",
+			"f.yaml": "id: f\n  sender: x\n",
+			"f.json": "{\"id\": \"f\",\n\n  \"sender\": }",
+			"g.yaml": "web_url: [\"https://a.com\", 1]\nid: g\n",
+		}[file]
+		path := filepath.Join(dir, file)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("error writing file %s: %s", path, err)
+		}
+
+		_, err := ReadSite(path)
+		var checkErr *CheckError
+		if !errors.As(err, &checkErr) {
+			t.Errorf("unexpected error reading %s: %v", file, err)
+			continue
+		}
+		found := false
+		for _, p := range checkErr.Problems {
+			if p.Line == expected.Line && p.Key == expected.Key {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("problem of %s not found in %s", file, err)
+		}
+	}
+}
+
+func TestMailSenderFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	Directory = dir
+	writeFiles(t, dir, map[string]string{filepath.Join(PluginsDirectory, MailSenderType+PluginExt): ""})
+
+	for _, test := range []struct {
+		sender, expected string
+		err              error
+	}{
+		{"username=\"user@example.com\"", "user@example.com", nil},
+		{"username=\"user@example.com\"\nfrom=\"noreply@example.com\"", "noreply@example.com", nil},
+		{"username=\"apikey\"\nfrom=\"noreply@example.com\"", "noreply@example.com", nil},
+		{"username=\"apikey\"", "", ErrMailInvalidFrom},
+		{"username=\"user@example.com\"\nfrom=\"noreply\"", "", ErrMailInvalidFrom},
+	} {
+		site, err := ParseSite([]byte("id=\"a\"\nsender_type=\"mail\"\n[sender]\nmailto=\"to@example.com\"\n" + test.sender))
+		if test.err != nil {
+			var checkErr *CheckError
+			if !errors.As(err, &checkErr) || len(checkErr.Problems) != 1 || !errors.Is(checkErr.Problems[0].Err, test.err) {
+				t.Errorf("unexpected error parsing sender %q: %v", test.sender, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("error parsing sender %q: %s", test.sender, err)
+			continue
+		}
+		if site.Mail.From.Address != test.expected {
+			t.Errorf("unexpected from of sender %q: %s", test.sender, site.Mail.From.Address)
+		}
+	}
+}
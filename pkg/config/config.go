@@ -3,15 +3,35 @@ package config
 import (
 	"errors"
 	"fmt"
-	"github.com/pelletier/go-toml"
 	"io/ioutil"
+	"net"
+	"os"
 	"path/filepath"
+	"strings"
 )
 
-// TODO tests
+const (
+	// Filename is the default filename of the web-msg-handler config
+	Filename = "config.toml"
 
-// Filename is the default filename of the web-msg-handler config
-const Filename = "config.toml"
+	// DefaultPort is the default port to listen to
+	DefaultPort = 8080
+
+	// DefaultVerbose is the default verbosity level (standard)
+	DefaultVerbose = 3
+
+	// DefaultPIDFile is the default path of the PID file
+	DefaultPIDFile = "/run/web-msg-handler.pid"
+
+	// DefaultMaxBodySize is the default maximum size of the body of the requests in bytes
+	DefaultMaxBodySize = 100 * 1024
+
+	// DefaultSenderTimeout is the default time that a sender have to send a message in seconds
+	DefaultSenderTimeout = 10
+
+	// DefaultShutdownTimeout is the default time to wait for the requests and deliveries in progress when shutting down in seconds
+	DefaultShutdownTimeout = 15
+)
 
 var (
 	// Directory is the default setting directory path
@@ -19,32 +39,202 @@ var (
 
 	// ErrInvalidPort is returned when the config have a invalid port
 	ErrInvalidPort = errors.New("invalid port: must be between 0 and 65535")
+
+	// ErrInvalidMaxBodySize is returned when the config have a negative max body size
+	ErrInvalidMaxBodySize = errors.New("invalid max_body_size: must not be negative")
+
+	// ErrInvalidSenderTimeout is returned when the config have a negative sender timeout
+	ErrInvalidSenderTimeout = errors.New("invalid sender_timeout: must not be negative")
+
+	// ErrInvalidShutdownTimeout is returned when the config have a negative shutdown timeout
+	ErrInvalidShutdownTimeout = errors.New("invalid shutdown_timeout: must not be negative")
+
+	// ErrInvalidTrustedProxy is returned when the config have a trusted proxy that is not an IP or a CIDR
+	ErrInvalidTrustedProxy = errors.New("invalid trusted_proxies: must be IPs or CIDRs (i.e. 10.0.0.0/8)")
+
+	// ErrAdminNoToken is returned when the config enables the admin API without a token
+	ErrAdminNoToken = errors.New("invalid admin config: admin_token required")
 )
 
 // Config represents the structure of the web-msg-handler config
 type Config struct {
-	Port       int    `toml:"port"`
-	Verbose    int    `toml:"verbose"`
-	PIDFile    string `toml:"pid_file"`
-	LogOutFile string `toml:"log_output_file"`
-	LogErrFile string `toml:"log_error_file"`
+	Port         int    `toml:"port"`
+	Verbose      int    `toml:"verbose"`
+	PIDFile      string `toml:"pid_file"`
+	LogOutFile   string `toml:"log_output_file"`
+	LogErrFile   string `toml:"log_error_file"`
+	LogFormat    string `toml:"log_format"`
+	LogSensitive bool   `toml:"log_sensitive"`
+
+	AccessLogFile   string `toml:"access_log_file"`
+	AccessLogFormat string `toml:"access_log_format"`
+
+	MaxBodySize     int64 `toml:"max_body_size"`
+	SenderTimeout   int   `toml:"sender_timeout"`
+	ShutdownTimeout int   `toml:"shutdown_timeout"`
+
+	Archive bool `toml:"archive"`
+
+	TrustedProxies []string `toml:"trusted_proxies"`
+
+	Maintenance        bool   `toml:"maintenance"`
+	MaintenanceMessage string `toml:"maintenance_message"`
+
+	ControlSocket string `toml:"control_socket"`
+
+	AdminAddress string `toml:"admin_address"`
+	AdminSocket  string `toml:"admin_socket"`
+	AdminToken   string `toml:"admin_token" secret:"true"`
 }
 
-// Load will read the config from Directory and return a Config object
+// Load will read the config from Directory and return a Config object.
+// Its settings are overridden by the environment variables and the Overrides (see Settings),
+// and the ones not defined anywhere take their default values. The config file is optional.
+// If the config has problems, a *CheckError with all of them is returned.
 func Load() (*Config, error) {
-	data, err := ioutil.ReadFile(filepath.Join(Directory, Filename))
+	return load(true)
+}
+
+// LoadUnresolved loads the config like Load, but without resolving its references to secrets, which are left as they are.
+// It's meant for the commands that only need to find the running instance (i.e. reload), where the secrets
+// may not be available (i.e. the systemd credentials).
+func LoadUnresolved() (*Config, error) {
+	return load(false)
+}
+
+// load reads the config from Directory (see Load), resolving its references to secrets if resolve is true
+func load(resolve bool) (*Config, error) {
+	path, err := Path()
 	if err != nil {
 		return nil, fmt.Errorf("error loading config: %w", err)
 	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+	return parse(path, data, resolve)
+}
+
+// Path returns the path of the config file of Directory. It's Filename, or a file with the same name and
+// other of the Extensions (i.e. "config.yaml") if it doesn't exist. It fails if several of them exist.
+func Path() (string, error) {
+	base := strings.TrimSuffix(Filename, filepath.Ext(Filename))
+	var found []string
+	for _, ext := range Extensions {
+		path := filepath.Join(Directory, base+ext)
+		if _, err := os.Stat(path); err == nil {
+			found = append(found, path)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return filepath.Join(Directory, Filename), nil
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("several config files found: %s", strings.Join(found, ", "))
+}
+
+// parse parses and checks the config provided in the format of the extension of file (TOML if it's unknown,
+// see Extensions). file is also the path used in the problems reported.
+// Its references to secrets are only resolved if resolve is true.
+func parse(file string, data []byte, resolve bool) (*Config, error) {
+	ch := newChecker(file, data)
+	if ch.tree == nil {
+		return nil, newCheckError(ch.problems)
+	}
+	ch.applyOverrides()
+	for k, v := range map[string]interface{}{
+		"port":     int64(DefaultPort),
+		"verbose":  int64(DefaultVerbose),
+		"pid_file": DefaultPIDFile,
+	} {
+		if !ch.tree.Has(k) {
+			ch.tree.Set(k, v)
+		}
+	}
+	if resolve {
+		ch.resolveSecrets(ch.tree, "")
+	}
 
 	var c Config
-	if err := toml.Unmarshal(data, &c); err != nil {
-		return nil, fmt.Errorf("error parsing config: %w", err)
+	if !ch.unmarshal(&c) {
+		return nil, newCheckError(ch.problems)
 	}
 
 	if c.Port < 0 || c.Port > 65535 {
-		return nil, ErrInvalidPort
+		ch.add("port", ErrInvalidPort)
+	}
+
+	switch {
+	case c.MaxBodySize < 0:
+		ch.add("max_body_size", ErrInvalidMaxBodySize)
+	case c.MaxBodySize == 0:
+		c.MaxBodySize = DefaultMaxBodySize
+	}
+
+	switch {
+	case c.SenderTimeout < 0:
+		ch.add("sender_timeout", ErrInvalidSenderTimeout)
+	case c.SenderTimeout == 0:
+		c.SenderTimeout = DefaultSenderTimeout
+	}
+
+	switch {
+	case c.ShutdownTimeout < 0:
+		ch.add("shutdown_timeout", ErrInvalidShutdownTimeout)
+	case c.ShutdownTimeout == 0:
+		c.ShutdownTimeout = DefaultShutdownTimeout
+	}
+
+	for _, p := range c.TrustedProxies {
+		if parseProxy(p) == nil {
+			ch.add("trusted_proxies", ErrInvalidTrustedProxy)
+			break
+		}
 	}
 
+	if (c.AdminAddress != "" || c.AdminSocket != "") && c.AdminToken == "" {
+		ch.add("admin_token", ErrAdminNoToken)
+	}
+
+	if err := newCheckError(ch.problems); err != nil {
+		return nil, err
+	}
 	return &c, nil
 }
+
+// IsTrustedProxy reports if the IP provided is a reverse proxy whose X-Real-IP header can be trusted:
+// a loopback address or one of the TrustedProxies.
+func (c *Config) IsTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, p := range c.TrustedProxies {
+		if n := parseProxy(p); n != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxy returns the network of the trusted proxy provided, which can be an IP or a CIDR,
+// or nil if it's not valid
+func parseProxy(p string) *net.IPNet {
+	if _, n, err := net.ParseCIDR(p); err == nil {
+		return n
+	}
+	ip := net.ParseIP(p)
+	if ip == nil {
+		return nil
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
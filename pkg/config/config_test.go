@@ -0,0 +1,110 @@
+package config
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	Directory = dir
+	defer func() { Overrides = make(map[string]string) }()
+
+	// No config file
+	c, err := Load()
+	if err != nil {
+		t.Fatalf("error loading config without file: %s", err)
+	}
+	if c.Port != DefaultPort || c.Verbose != DefaultVerbose || c.PIDFile != DefaultPIDFile || c.Archive {
+		t.Errorf("unexpected default config: %+v", c)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, Filename), []byte("port=1\nverbose=0\nlog_format=\"json\"\n"), 0644); err != nil {
+		t.Fatalf("error writing config: %s", err)
+	}
+	os.Setenv("WMH_PORT", "2")
+	os.Setenv("WMH_ARCHIVE", "true")
+	os.Setenv("WMH_MAX_BODY_SIZE", "10")
+	os.Setenv("WMH_ADMIN_TOKEN", "env-token")
+	defer os.Unsetenv("WMH_ADMIN_TOKEN")
+	defer os.Unsetenv("WMH_PORT")
+	defer os.Unsetenv("WMH_ARCHIVE")
+	defer os.Unsetenv("WMH_MAX_BODY_SIZE")
+	Overrides["port"] = "3"
+	Overrides["log_format"] = "text"
+	Overrides["admin_token"] = "flag-token"
+
+	c, err = Load()
+	if err != nil {
+		t.Fatalf("error loading config: %s", err)
+	}
+	if c.Port != 3 || c.Verbose != 0 || c.LogFormat != "text" || !c.Archive || c.MaxBodySize != 10 ||
+		c.AdminToken != "env-token" {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	delete(Overrides, "admin_token")
+
+	Overrides["trusted_proxies"] = "10.0.0.0/8, 192.168.1.1"
+	c, err = Load()
+	if err != nil {
+		t.Fatalf("error loading config with trusted proxies: %s", err)
+	}
+	if len(c.TrustedProxies) != 2 || !c.IsTrustedProxy(net.ParseIP("10.1.2.3")) ||
+		!c.IsTrustedProxy(net.ParseIP("192.168.1.1")) || c.IsTrustedProxy(net.ParseIP("192.168.1.2")) {
+		t.Errorf("unexpected trusted proxies: %q", c.TrustedProxies)
+	}
+
+	Overrides["trusted_proxies"] = "10.0.0.0/33"
+	_, err = Load()
+	if err == nil || err.Error() != "--trusted-proxies: "+ErrInvalidTrustedProxy.Error() {
+		t.Errorf("unexpected error: %v", err)
+	}
+	delete(Overrides, "trusted_proxies")
+
+	Overrides["sender_timeout"] = "soon"
+	_, err = Load()
+	if err == nil || err.Error() != "--sender-timeout: invalid sender_timeout: soon" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSecretSettings(t *testing.T) {
+	for _, s := range Settings() {
+		if s.Secret != (s.Key == "admin_token") {
+			t.Errorf("unexpected secret of setting %s: %t", s.Key, s.Secret)
+		}
+	}
+}
+
+func TestLoadUnresolved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	Directory = dir
+	os.Unsetenv(CredentialsDirectoryEnv)
+
+	data := []byte("admin_socket=\"/run/admin.sock\"\nadmin_token=\"${cred:admin-token}\"\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, Filename), data, 0644); err != nil {
+		t.Fatalf("error writing config: %s", err)
+	}
+
+	if _, err := Load(); err == nil {
+		t.Error("config with unavailable credential loaded")
+	}
+	c, err := LoadUnresolved()
+	if err != nil {
+		t.Fatalf("error loading config without resolving secrets: %s", err)
+	}
+	if c.AdminToken != "${cred:admin-token}" {
+		t.Errorf("unexpected admin_token: %s", c.AdminToken)
+	}
+}
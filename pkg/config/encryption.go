@@ -0,0 +1,31 @@
+package config
+
+import (
+	"errors"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/encryption"
+	"path/filepath"
+)
+
+// ErrInvalidEncryption is returned when the [encryption] of a site doesn't define exactly one recipient
+var ErrInvalidEncryption = errors.New("invalid encryption: either pgp_key_file or age_recipient required")
+
+// encryptionConfig is the internal type for unmarshalling the [encryption] table of the site configs
+type encryptionConfig struct {
+	PGPKeyFile   string `toml:"pgp_key_file"`
+	AgeRecipient string `toml:"age_recipient"`
+}
+
+// encrypter returns the Encrypter of the config. The PGP key file path is relative to Directory if not absolute.
+func (ec *encryptionConfig) encrypter() (encryption.Encrypter, error) {
+	switch {
+	case ec.PGPKeyFile != "" && ec.AgeRecipient == "":
+		path := ec.PGPKeyFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(Directory, path)
+		}
+		return encryption.LoadPGP(path)
+	case ec.AgeRecipient != "" && ec.PGPKeyFile == "":
+		return encryption.NewAge(ec.AgeRecipient)
+	}
+	return nil, ErrInvalidEncryption
+}
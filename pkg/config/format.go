@@ -0,0 +1,306 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extensions are the extensions of the config files supported (TOML, YAML and JSON), in order of precedence
+var Extensions = []string{".toml", ".yaml", ".yml", ".json"}
+
+// regexYAMLLine matches the line of the errors of yaml, like "yaml: line 3: mapping values are not allowed"
+var regexYAMLLine = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// IsConfigFile returns if the file name provided is a config file: it's not hidden and it has one of the Extensions.
+// Editor backups (like "site.toml~" or ".site.toml.swp") and examples (like "site.toml.example") are not.
+func IsConfigFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range Extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadMap reads the config file provided in any of the formats supported and returns its content as a map.
+// The references to secrets are not resolved.
+func ReadMap(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := newChecker(path, data)
+	if ch.tree == nil {
+		return nil, newCheckError(ch.problems)
+	}
+	return ch.tree.ToMap(), nil
+}
+
+// MarshalMap encodes the config provided in the format of the file provided (see Extensions)
+func MarshalMap(path string, m map[string]interface{}) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.Marshal(m)
+	case ".json":
+		data, err := json.MarshalIndent(m, "", "  ")
+		return append(data, '\n'), err
+	}
+
+	tree, err := toml.TreeFromMap(m)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Marshal()
+}
+
+// SetKey returns the content of the config file provided with the top-level key provided set to the value provided
+// (a bool or a string), or removed if it's nil. TOML files are edited line by line, preserving their comments and order
+// (see setTopLevelKey), while the rest of formats are encoded again (see MarshalMap).
+func SetKey(path, key string, value interface{}) ([]byte, error) {
+	if ext := strings.ToLower(filepath.Ext(path)); ext != filepath.Ext(Filename) {
+		m, err := ReadMap(path)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			delete(m, key)
+		} else {
+			m[key] = value
+		}
+		return MarshalMap(path, m)
+	}
+
+	var v string
+	switch value := value.(type) {
+	case nil:
+	case bool:
+		v = strconv.FormatBool(value)
+	case string:
+		v = quoteTOML(value)
+	default:
+		return nil, fmt.Errorf("unsupported value of %s: %v", key, value)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := setTopLevelKey(strings.Split(string(data), "\n"), key, v)
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// loadMap parses the config file provided if it's in YAML or JSON, and returns it as a TOML tree.
+// The lines of its keys are saved like load does. It returns false if it's a TOML file.
+func (ch *checker) loadMap(file string, data []byte) (*toml.Tree, bool) {
+	var (
+		m     map[string]interface{}
+		lines map[string]int
+		p     *Problem
+	)
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		m, lines, p = decodeYAML(data)
+	case ".json":
+		m, lines, p = decodeJSON(data)
+	default:
+		return nil, false
+	}
+	if p != nil {
+		p.File = file
+		ch.problems = append(ch.problems, *p)
+		return nil, true
+	}
+
+	for key, line := range lines {
+		if _, saved := ch.lines[key]; !saved {
+			ch.lines[key] = line
+			if file != ch.file {
+				ch.files[key] = file
+			}
+		}
+	}
+
+	problems := len(ch.problems)
+	m = ch.normalizeMap(m, "")
+	if len(ch.problems) != problems {
+		return nil, true
+	}
+
+	tree, err := toml.TreeFromMap(m)
+	if err != nil {
+		ch.problems = append(ch.problems, Problem{File: file, Err: err})
+		return nil, true
+	}
+	return tree, true
+}
+
+// decodeYAML decodes the YAML config provided, returning it and the lines of its keys
+func decodeYAML(data []byte) (map[string]interface{}, map[string]int, *Problem) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		p := &Problem{Err: err}
+		if m := regexYAMLLine.FindStringSubmatch(err.Error()); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Err = errors.New(err.Error()[len(m[0]):])
+		}
+		return nil, nil, p
+	}
+
+	m := make(map[string]interface{})
+	lines := make(map[string]int)
+	if len(doc.Content) == 0 {
+		return m, lines, nil
+	}
+	if err := doc.Decode(&m); err != nil {
+		return nil, nil, &Problem{Line: doc.Content[0].Line, Err: err}
+	}
+	yamlLines(doc.Content[0], "", lines)
+	return m, lines, nil
+}
+
+// yamlLines saves in lines the lines of the keys of the YAML mapping provided. prefix is the path of the mapping.
+func yamlLines(n *yaml.Node, prefix string, lines map[string]int) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := prefix + n.Content[i].Value
+		lines[key] = n.Content[i].Line
+		yamlLines(n.Content[i+1], key+".", lines)
+	}
+}
+
+// decodeJSON decodes the JSON config provided, returning it and the lines of its keys
+func decodeJSON(data []byte) (map[string]interface{}, map[string]int, *Problem) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var m map[string]interface{}
+	if err := d.Decode(&m); err != nil {
+		p := &Problem{Err: err}
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			p.Line = lineAt(data, syntaxErr.Offset)
+		case errors.As(err, &typeErr):
+			p.Line = lineAt(data, typeErr.Offset)
+		}
+		return nil, nil, p
+	}
+
+	lines := make(map[string]int)
+	d = json.NewDecoder(bytes.NewReader(data))
+	_ = jsonLines(data, d, "", true, lines)
+	return m, lines, nil
+}
+
+// jsonLines reads the next JSON value of the decoder provided, saving in lines the lines of its keys if record is true.
+// prefix is the path of the value. The keys of the objects inside arrays are not saved.
+func jsonLines(data []byte, d *json.Decoder, prefix string, record bool, lines map[string]int) error {
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		for d.More() {
+			keyTok, err := d.Token()
+			if err != nil {
+				return err
+			}
+			key := prefix + fmt.Sprint(keyTok)
+			if record {
+				lines[key] = lineAt(data, d.InputOffset())
+			}
+			if err := jsonLines(data, d, key+".", record, lines); err != nil {
+				return err
+			}
+		}
+		_, err = d.Token()
+	case json.Delim('['):
+		for d.More() {
+			if err := jsonLines(data, d, prefix, false, lines); err != nil {
+				return err
+			}
+		}
+		_, err = d.Token()
+	}
+	return err
+}
+
+// lineAt returns the line of the offset provided of data
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// normalizeMap converts the values of the map provided to the types supported by TOML, collecting a problem
+// for each value that cannot be converted. The null values are removed. prefix is the path of the map.
+func (ch *checker) normalizeMap(m map[string]interface{}, prefix string) map[string]interface{} {
+	for k, v := range m {
+		if v == nil {
+			delete(m, k)
+			continue
+		}
+		nv, err := ch.normalizeValue(v, prefix+k)
+		if err != nil {
+			ch.add(prefix+k, err)
+			continue
+		}
+		m[k] = nv
+	}
+	return m
+}
+
+// normalizeValue converts the value of the key provided to a type supported by TOML
+func (ch *checker) normalizeValue(v interface{}, key string) (interface{}, error) {
+	switch v := v.(type) {
+	case string, bool, int64, float64, time.Time:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		return ch.normalizeMap(v, key+"."), nil
+	case []interface{}:
+		var kind reflect.Type
+		for i, item := range v {
+			if item == nil {
+				return nil, fmt.Errorf("invalid %s: arrays cannot contain null", key)
+			}
+			ni, err := ch.normalizeValue(item, key)
+			if err != nil {
+				return nil, err
+			}
+			if kind != nil && reflect.TypeOf(ni) != kind {
+				return nil, fmt.Errorf("invalid %s: arrays cannot contain mixed types", key)
+			}
+			kind, v[i] = reflect.TypeOf(ni), ni
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid %s: unsupported value of type %T", key, v)
+}
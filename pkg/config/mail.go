@@ -0,0 +1,122 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/mail"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/sanitation"
+	netmail "net/mail"
+	"path/filepath"
+)
+
+// MailSenderType is the sender_type of the sites whose messages are composed by web-msg-handler and sent by email
+const MailSenderType = "mail"
+
+var (
+	// ErrMailNoRecipient is returned when the [sender] of a mail site doesn't have a valid mailto
+	ErrMailNoRecipient = errors.New("invalid mail sender: mailto must be a valid email")
+
+	// ErrMailInvalidFrom is returned when the [sender] of a mail site doesn't have a valid from,
+	// which is required when its username is not an email
+	ErrMailInvalidFrom = errors.New("invalid mail sender: from must be a valid email (required if username is not an email)")
+
+	// ErrDKIMIncomplete is returned when the [sender.dkim] of a mail site doesn't define its domain, selector or key_file
+	ErrDKIMIncomplete = errors.New("invalid mail sender dkim: domain, selector and key_file required")
+)
+
+// MailSender is the config of the sites that send their messages by email (see MailSenderType).
+// Their messages are composed with the From display name, and the Cc and Bcc recipients defined here.
+type MailSender struct {
+	From mail.Address
+	To   mail.Address
+	Cc   []mail.Address
+	Bcc  []mail.Address
+
+	// WebsiteName is the name of the site, used in the default subject of the messages
+	WebsiteName string
+
+	// DKIM is the config for signing the messages, nil if they're not signed
+	DKIM *mail.DKIM
+}
+
+// mailSenderConfig is the internal type for unmarshalling the [sender] table of the mail sites
+type mailSenderConfig struct {
+	Sender struct {
+		WebsiteName string   `toml:"website_name"`
+		Mailto      string   `toml:"mailto"`
+		Username    string   `toml:"username"`
+		From        string   `toml:"from"`
+		FromName    string   `toml:"from_name"`
+		Cc          []string `toml:"cc"`
+		Bcc         []string `toml:"bcc"`
+		DKIM        *struct {
+			Domain   string   `toml:"domain"`
+			Selector string   `toml:"selector"`
+			KeyFile  string   `toml:"key_file"`
+			Headers  []string `toml:"headers"`
+		} `toml:"dkim"`
+	} `toml:"sender"`
+}
+
+// parse returns the MailSender of the config. The From address will be the username if not defined,
+// and the From display name will be the website name if not defined.
+// The cc and bcc addresses can have a display name (i.e. "John <john@example.com>").
+// The DKIM key file path is relative to Directory if not absolute.
+func (msc *mailSenderConfig) parse() (*MailSender, error) {
+	s := msc.Sender
+	to, err := netmail.ParseAddress(s.Mailto)
+	if err != nil {
+		return nil, ErrMailNoRecipient
+	}
+
+	from := s.From
+	if from == "" {
+		from = s.Username
+	}
+	if !sanitation.IsValidMail(from) {
+		return nil, ErrMailInvalidFrom
+	}
+
+	ms := &MailSender{
+		From:        mail.Address{Name: s.FromName, Address: from},
+		To:          *to,
+		WebsiteName: s.WebsiteName,
+	}
+	if ms.From.Name == "" {
+		ms.From.Name = s.WebsiteName
+	}
+
+	if ms.Cc, err = parseAddresses(s.Cc); err != nil {
+		return nil, fmt.Errorf("invalid mail sender cc: %w", err)
+	}
+	if ms.Bcc, err = parseAddresses(s.Bcc); err != nil {
+		return nil, fmt.Errorf("invalid mail sender bcc: %w", err)
+	}
+
+	if d := s.DKIM; d != nil {
+		if d.Domain == "" || d.Selector == "" || d.KeyFile == "" {
+			return nil, ErrDKIMIncomplete
+		}
+		keyPath := d.KeyFile
+		if !filepath.IsAbs(keyPath) {
+			keyPath = filepath.Join(Directory, keyPath)
+		}
+		if ms.DKIM, err = mail.NewDKIM(d.Domain, d.Selector, keyPath, d.Headers); err != nil {
+			return nil, err
+		}
+	}
+	return ms, nil
+}
+
+// parseAddresses parses a list of addresses
+func parseAddresses(list []string) ([]mail.Address, error) {
+	addrs := make([]mail.Address, 0, len(list))
+	for _, s := range list {
+		addr, err := netmail.ParseAddress(s)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing address %q: %w", s, err)
+		}
+		addrs = append(addrs, *addr)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// regexTableHeader matches the lines of a TOML file that start a table
+var regexTableHeader = regexp.MustCompile(`^\s*\[`)
+
+// SetMaintenance enables or disables the maintenance in the config of Directory, setting its keys "maintenance"
+// and "maintenance_message". The message is left as it is if it's nil, and removed if it's empty.
+// The rest of the file (comments included) is preserved, and it's written atomically.
+// The running instances must reload the config to apply it. Only TOML configs are supported.
+func SetMaintenance(enabled bool, message *string) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	if ext := filepath.Ext(path); ext != filepath.Ext(Filename) {
+		return fmt.Errorf("the maintenance can only be changed in TOML configs: edit %s manually", path)
+	}
+	// The config is created if it doesn't exist
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	lines = setTopLevelKey(lines, "maintenance", strconv.FormatBool(enabled))
+	switch {
+	case message == nil:
+	case *message == "":
+		lines = setTopLevelKey(lines, "maintenance_message", "")
+	default:
+		lines = setTopLevelKey(lines, "maintenance_message", quoteTOML(*message))
+	}
+	data = []byte(strings.Join(lines, "\n"))
+
+	// The secrets may only be available for the running instance
+	if _, err := parse(path, data, false); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(Directory, ".config-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error writing temporary file: %w", err)
+	}
+	if err := f.Chmod(perm); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error setting permissions of temporary file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error renaming temporary file: %w", err)
+	}
+	return nil
+}
+
+// setTopLevelKey sets the value of a key outside any table in the lines of the TOML file provided.
+// The key is removed if the value is empty, and it's added before the first table if it doesn't exist.
+func setTopLevelKey(lines []string, key, value string) []string {
+	regexKey := regexp.MustCompile(`^\s*` + regexp.QuoteMeta(key) + `\s*=`)
+
+	end := len(lines)
+	for i, line := range lines {
+		if regexTableHeader.MatchString(line) {
+			end = i
+			break
+		}
+		if !regexKey.MatchString(line) {
+			continue
+		}
+		if value == "" {
+			return append(lines[:i], lines[i+1:]...)
+		}
+		lines[i] = key + "=" + value
+		return lines
+	}
+
+	if value == "" {
+		return lines
+	}
+	// Skip the empty lines before the first table
+	for end > 0 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return append(lines[:end], append([]string{key + "=" + value}, lines[end:]...)...)
+}
+
+// quoteTOML returns the string provided as a TOML basic string
+func quoteTOML(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case unicode.IsControl(r):
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
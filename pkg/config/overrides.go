@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables that override the settings of the config (i.e. WMH_PORT)
+const EnvPrefix = "WMH_"
+
+// Overrides are the values of the settings that override the ones of the config file and the environment variables,
+// where the key is the key of the setting (i.e. "port"). They're meant to be set from command-line flags.
+var Overrides = make(map[string]string)
+
+// Setting is a setting of Config that can be overridden, identified by its Key in the config file.
+// Secret settings (tagged `secret:"true"` in Config) have no command-line flag, since the flags are visible
+// to every user (i.e. in ps) and passed on to the new executable when upgrading; they're ignored in Overrides.
+type Setting struct {
+	Key    string
+	Kind   reflect.Kind
+	Secret bool
+}
+
+// Env returns the name of the environment variable that overrides the setting (i.e. WMH_PID_FILE)
+func (s Setting) Env() string {
+	return EnvPrefix + strings.ToUpper(s.Key)
+}
+
+// Flag returns the name of the command-line flag of the setting (i.e. pid-file)
+func (s Setting) Flag() string {
+	return strings.ReplaceAll(s.Key, "_", "-")
+}
+
+// Settings returns every setting of Config, in the order of its fields
+func Settings() []Setting {
+	t := reflect.TypeOf(Config{})
+	settings := make([]Setting, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		settings = append(settings, Setting{
+			Key:    strings.Split(f.Tag.Get("toml"), ",")[0],
+			Kind:   f.Type.Kind(),
+			Secret: f.Tag.Get("secret") == "true",
+		})
+	}
+	return settings
+}
+
+// applyOverrides sets in the config being checked the settings defined by the environment variables (see Setting.Env)
+// and Overrides, in that order. The problems found are reported with the variable or flag as file.
+func (ch *checker) applyOverrides() {
+	for _, s := range Settings() {
+		if v, ok := os.LookupEnv(s.Env()); ok && v != "" {
+			ch.override(s, "$"+s.Env(), v)
+		}
+		if v, ok := Overrides[s.Key]; ok && !s.Secret {
+			ch.override(s, "--"+s.Flag(), v)
+		}
+	}
+}
+
+// override sets the setting provided to the value provided, parsed as its kind. source is where the value comes from.
+func (ch *checker) override(s Setting, source, v string) {
+	var (
+		value interface{} = v
+		err   error
+	)
+	switch s.Kind {
+	case reflect.Bool:
+		value, err = strconv.ParseBool(v)
+	case reflect.Int, reflect.Int64:
+		value, err = strconv.ParseInt(v, 10, 64)
+	case reflect.Slice:
+		// Lists are separated by commas (i.e. "10.0.0.1,10.0.0.2")
+		var list []interface{}
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		value = list
+	}
+	if err != nil {
+		ch.problems = append(ch.problems, Problem{File: source, Key: s.Key, Err: fmt.Errorf("invalid %s: %s", s.Key, v)})
+		return
+	}
+
+	ch.tree.Set(s.Key, value)
+	ch.lines[s.Key], ch.files[s.Key] = 0, source
+}
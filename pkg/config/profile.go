@@ -0,0 +1,112 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ProfilesDirectory is the name of the subdirectory (of Directory) that contains the profiles.
+	// A profile is a partial site config (without id) that the site configs can reference by its name
+	// (the filename without extension) with the key "profile".
+	ProfilesDirectory = "profiles"
+
+	// DefaultProfile is the name of the profile applied to every site config
+	DefaultProfile = "default"
+)
+
+// ErrProfileForbiddenKey is returned when a profile defines a key that must be defined by the site configs
+var ErrProfileForbiddenKey = errors.New("id and profile cannot be defined in a profile")
+
+// profile is a profile file
+type profile struct {
+	path string
+	data []byte
+}
+
+// loadProfiles reads the profiles of ProfilesDirectory, and returns a map where the key is their name.
+// It returns no profiles if the directory doesn't exist. The problems found reading them are returned too.
+func loadProfiles() (map[string]*profile, []Problem) {
+	dir := filepath.Join(Directory, ProfilesDirectory)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, []Problem{{File: dir, Err: fmt.Errorf("error listing profiles directory: %w", err)}}
+	}
+
+	var problems []Problem
+	profiles := make(map[string]*profile, len(files))
+	for _, f := range files {
+		if !f.Mode().IsRegular() || !IsConfigFile(f.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			problems = append(problems, Problem{File: path, Err: err})
+			continue
+		}
+		name := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		if other, exists := profiles[name]; exists {
+			problems = append(problems, Problem{
+				File: path,
+				Err:  fmt.Errorf("duplicate profile %s (also defined in %s)", name, other.path),
+			})
+			continue
+		}
+		profiles[name] = &profile{path: path, data: data}
+	}
+	return profiles, problems
+}
+
+// mergeProfile merges the profile with the name provided into the site config being checked, adding the keys
+// that it doesn't define. The tables are merged key by key. key is the key of the site config that references
+// the profile, used for reporting it if it doesn't exist.
+func (ch *checker) mergeProfile(profiles map[string]*profile, name, key string) {
+	p, ok := profiles[name]
+	if !ok {
+		if key != "" {
+			ch.add(key, fmt.Errorf("profile %s not found in %s", name, filepath.Join(Directory, ProfilesDirectory)))
+		}
+		return
+	}
+
+	tree := ch.load(p.path, p.data)
+	if tree == nil {
+		return
+	}
+	for _, k := range []string{"id", "profile"} {
+		if tree.Has(k) {
+			ch.problems = append(ch.problems, Problem{
+				File: p.path,
+				Key:  k,
+				Line: tree.GetPosition(k).Line,
+				Err:  ErrProfileForbiddenKey,
+			})
+		}
+	}
+	mergeTrees(ch.tree, tree)
+}
+
+// mergeTrees adds to dst the keys of src that it doesn't define. The tables defined in both are merged recursively.
+func mergeTrees(dst, src *toml.Tree) {
+	for _, k := range src.Keys() {
+		if !dst.Has(k) {
+			dst.Set(k, src.Get(k))
+			continue
+		}
+		dstSub, dstIsTree := dst.Get(k).(*toml.Tree)
+		srcSub, srcIsTree := src.Get(k).(*toml.Tree)
+		if dstIsTree && srcIsTree {
+			mergeTrees(dstSub, srcSub)
+		}
+	}
+}
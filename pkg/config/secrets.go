@@ -0,0 +1,139 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CredentialsDirectoryEnv is the environment variable with the directory of the credentials provided by systemd
+// (see LoadCredential= in systemd.exec(5))
+const CredentialsDirectoryEnv = "CREDENTIALS_DIRECTORY"
+
+// regexSecret matches the references to secrets of the config values: ${env:VAR}, ${file:/path} and ${cred:name}
+var regexSecret = regexp.MustCompile(`\$\{(env|file|cred):([^}]*)\}`)
+
+// resolveSecrets replaces the references to secrets of every string of the tree provided (including the ones in arrays
+// and nested tables) with their values. prefix is the path of the tree.
+// The problems collected never contain the values of the secrets, even the ones collected after resolving them
+// (see redact).
+func (ch *checker) resolveSecrets(tree *toml.Tree, prefix string) {
+	for _, k := range tree.Keys() {
+		switch v := tree.Get(k).(type) {
+		case *toml.Tree:
+			ch.resolveSecrets(v, prefix+k+".")
+		case []*toml.Tree:
+			for _, sub := range v {
+				ch.resolveSecrets(sub, prefix+k+".")
+			}
+		case string:
+			if s, ok := ch.resolveString(prefix+k, v); ok {
+				tree.Set(k, s)
+			}
+		case []interface{}:
+			for i, item := range v {
+				if s, isString := item.(string); isString {
+					if s, ok := ch.resolveString(prefix+k, s); ok {
+						v[i] = s
+					}
+				}
+			}
+		}
+	}
+}
+
+// resolveString returns the string provided with its references to secrets replaced with their values.
+// It returns false if it has no references or they cannot be resolved, collecting a problem in the line of key.
+func (ch *checker) resolveString(key, s string) (string, bool) {
+	if !regexSecret.MatchString(s) {
+		return s, false
+	}
+
+	var resolveErr error
+	resolved := regexSecret.ReplaceAllStringFunc(s, func(ref string) string {
+		m := regexSecret.FindStringSubmatch(ref)
+		secret, err := readSecret(m[1], m[2])
+		if err != nil && resolveErr == nil {
+			resolveErr = fmt.Errorf("error resolving %s in %s: %w", ref, key, err)
+		}
+		if secret != "" {
+			ch.secrets[secret] = ref
+		}
+		return secret
+	})
+	if resolveErr != nil {
+		ch.add(key, resolveErr)
+		return s, false
+	}
+	return resolved, true
+}
+
+// redact returns the error provided with the values of the secrets resolved replaced with their references
+// (i.e. "${env:VAR}"), or the same error if it doesn't contain any of them.
+// The longest values are replaced first, so the ones that contain others are not revealed partially.
+func (ch *checker) redact(err error) error {
+	if err == nil || len(ch.secrets) == 0 {
+		return err
+	}
+
+	values := make([]string, 0, len(ch.secrets))
+	for v := range ch.secrets {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	msg := err.Error()
+	for _, v := range values {
+		msg = strings.ReplaceAll(msg, v, ch.secrets[v])
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return errors.New(msg)
+}
+
+// readSecret returns the secret of the kind provided (env, file or cred) with the name provided.
+// The trailing new lines of the files are removed, and relative paths are relative to Directory.
+func readSecret(kind, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty name")
+	}
+
+	var path string
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not defined", name)
+		}
+		return v, nil
+	case "file":
+		path = name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(Directory, path)
+		}
+	case "cred":
+		dir := os.Getenv(CredentialsDirectoryEnv)
+		if dir == "" {
+			return "", fmt.Errorf("environment variable %s not defined", CredentialsDirectoryEnv)
+		}
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return "", fmt.Errorf("invalid credential name %s", name)
+		}
+		path = filepath.Join(dir, name)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
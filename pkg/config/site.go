@@ -2,17 +2,48 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/pelletier/go-toml"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/encryption"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/templates"
 	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
 // Site is the object generated for each site when loading the config.
-// It consists in a RecaptchaSecret, a SenderName (that will match the name of a plugin)
-// and a ConfigJSON that will be generated from the settings.toml
+// It consists in its ID, the Path of its config file,
+// its Name (the website_name of its sender config, or its ID if not defined),
+// a RecaptchaSecret, a SenderName (that will match the name of a plugin),
+// a ConfigJSON that will be generated from the settings.toml,
+// the WebUrls allowed as CORS origins (see AllowedOrigin),
+// the Profile merged with its config (see ProfilesDirectory),
+// the Language of the messages replied to the visitors when they don't request a language available,
+// the config of the AutoReply sent to the visitors (nil if disabled),
+// the Templates of the messages passed to the sender (nil if not defined),
+// the config of the Mail messages (only for MailSenderType sites, nil otherwise),
+// the Encrypter of the messages passed to the sender (nil if they're not encrypted),
+// the RetentionDays of its archived messages (0 if they're kept forever),
+// and if it's Enabled (disabled sites reply the MaintenanceMessage to every message).
 type Site struct {
-	RecaptchaSecret, WebUrl, SenderName, ConfigJSON string
+	ID, Path, Name, RecaptchaSecret, SenderName, ConfigJSON, Language string
+	MaintenanceMessage, Profile                                       string
+	Enabled                                                           bool
+	RetentionDays                                                     int
+	WebUrls                                                           []string
+	AutoReply                                                         *AutoReply
+	Templates                                                         *templates.Set
+	Mail                                                              *MailSender
+	Encrypter                                                         encryption.Encrypter
+
+	// idLine is the line where the ID is defined, for reporting ID collisions
+	idLine int
+
+	// effective is the config of the site merged with its profiles, in TOML (see EffectiveConfig)
+	effective string
 }
 
 // siteConfig is the internal type for unmarshalling the site configs.
@@ -20,14 +51,57 @@ type siteConfig struct {
 	ID              string                 `toml:"id"`
 	RecaptchaSecret string                 `toml:"recaptcha_secret"`
 	SenderType      string                 `toml:"sender_type"`
-	WebUrl          string                 `toml:"web_url"`
+	Profile         string                 `toml:"profile"`
+	WebUrl          interface{}            `toml:"web_url"`
+	Language        string                 `toml:"language"`
+	RetentionDays   int                    `toml:"retention_days"`
+	Enabled         *bool                  `toml:"enabled"`
+	Maintenance     string                 `toml:"maintenance_message"`
 	SenderConfig    map[string]interface{} `toml:"sender"`
+	AutoReply       *AutoReply             `toml:"autoreply"`
+	Templates       *templatesConfig       `toml:"templates"`
+	Encryption      *encryptionConfig      `toml:"encryption"`
+}
+
+// templatesConfig is the internal type for unmarshalling the paths of the template files of a site config.
+// Relative paths are relative to Directory.
+type templatesConfig struct {
+	Subject string `toml:"subject"`
+	Text    string `toml:"text"`
+	HTML    string `toml:"html"`
 }
 
-// SitesDirectory is the name of the subdirectory (of Directory) that contains the site configs.
-const SitesDirectory = "sites"
+const (
+	// SitesDirectory is the name of the subdirectory (of Directory) that contains the site configs.
+	SitesDirectory = "sites"
+
+	// PluginsDirectory is the name of the subdirectory (of Directory) that contains the plugins (see package plugin)
+	PluginsDirectory = "plugins"
+
+	// PluginExt is the extension of the plugins
+	PluginExt = ".js"
+
+	// AnyOrigin is the web_url that allows any origin
+	AnyOrigin = "*"
+)
+
+var (
+	// ErrInvalidWebUrl is returned when the web_url of a site config is not a string or a list of strings
+	ErrInvalidWebUrl = errors.New("invalid web_url: must be a string or a list of strings")
+
+	// ErrSiteNoID is returned when a site config doesn't define its id
+	ErrSiteNoID = errors.New("id required")
+
+	// ErrSiteNoSenderType is returned when a site config doesn't define its sender_type
+	ErrSiteNoSenderType = errors.New("sender_type required")
+
+	// ErrInvalidRetentionDays is returned when the retention_days of a site config is negative
+	ErrInvalidRetentionDays = errors.New("invalid retention_days: must not be negative")
+)
 
 // LoadSites will read the site configs and return a map where the key is the site ID and the value is the site itself.
+// They can be in any of the formats supported (see IsConfigFile); the rest of files are ignored.
+// If any site config has problems (or their IDs collide), a *CheckError with all of them is returned.
 func LoadSites() (map[string]*Site, error) {
 	path := filepath.Join(Directory, SitesDirectory)
 
@@ -36,43 +110,262 @@ func LoadSites() (map[string]*Site, error) {
 		return nil, fmt.Errorf("error listing sites directory: %w", err)
 	}
 
+	profiles, problems := loadProfiles()
 	sitesMap := make(map[string]*Site, len(sites))
 	for _, s := range sites {
-		if !s.Mode().IsRegular() {
+		if !s.Mode().IsRegular() || !IsConfigFile(s.Name()) {
 			continue
 		}
 
 		sitePath := filepath.Join(path, s.Name())
 		data, err := ioutil.ReadFile(sitePath)
 		if err != nil {
-			return nil, fmt.Errorf("error reading file \"%s\": %w", sitePath, err)
+			problems = append(problems, Problem{File: sitePath, Err: err})
+			continue
 		}
 
-		var sc siteConfig
-		if err := toml.Unmarshal(data, &sc); err != nil {
-			return nil, fmt.Errorf("error parsing site config from file \"%s\": %w", sitePath, err)
+		site, siteProblems := parseSite(sitePath, data, profiles)
+		problems = append(problems, siteProblems...)
+		if site == nil {
+			continue
 		}
 
-		if _, exists := sitesMap[sc.ID]; exists {
-			return nil, fmt.Errorf("site ID collition: %s", sc.ID)
+		if other, exists := sitesMap[site.ID]; exists {
+			problems = append(problems, Problem{
+				File: sitePath,
+				Line: site.idLine,
+				Err:  fmt.Errorf("duplicate site ID %s (also defined in %s)", site.ID, other.Path),
+			})
+			continue
 		}
+		sitesMap[site.ID] = site
+	}
 
-		configJSON, err := json.Marshal(sc.SenderConfig)
-		if err != nil {
-			return nil, fmt.Errorf("error generating config JSON for plugin %s: %w", s.Name(), err)
+	if err := newCheckError(problems); err != nil {
+		return nil, err
+	}
+	return sitesMap, nil
+}
+
+// ReadSite reads the site config of the file provided, in the format of its extension (see Extensions)
+func ReadSite(sitePath string) (*Site, error) {
+	data, err := ioutil.ReadFile(sitePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file \"%s\": %w", sitePath, err)
+	}
+
+	profiles, problems := loadProfiles()
+	site, siteProblems := parseSite(sitePath, data, profiles)
+	if site == nil {
+		return nil, newCheckError(append(problems, siteProblems...))
+	}
+	return site, nil
+}
+
+// ParseSite parses the site config provided in TOML, since it has no file to take the format from
+// (see ReadSite for the other formats), merged with the profiles of ProfilesDirectory.
+// If it has problems, a *CheckError with all of them is returned.
+func ParseSite(data []byte) (*Site, error) {
+	profiles, problems := loadProfiles()
+	site, siteProblems := parseSite("", data, profiles)
+	if site == nil {
+		return nil, newCheckError(append(problems, siteProblems...))
+	}
+	return site, nil
+}
+
+// parseSite parses the site config provided in the format of the extension of the file provided
+// (TOML if it's unknown or there's no file, see Extensions), and sets its Path to that file.
+// It's merged with the profile that it references (if any) and the DefaultProfile (if it exists), in that order.
+// It returns every problem found, and a nil site if there are any.
+func parseSite(file string, data []byte, profiles map[string]*profile) (*Site, []Problem) {
+	ch := newChecker(file, data)
+	if ch.tree == nil {
+		return nil, ch.problems
+	}
+
+	if name, ok := ch.tree.Get("profile").(string); ok && name != "" {
+		ch.mergeProfile(profiles, name, "profile")
+	}
+	ch.mergeProfile(profiles, DefaultProfile, "")
+	effective, err := ch.tree.ToTomlString()
+	if err != nil {
+		ch.add("", err)
+	}
+	ch.resolveSecrets(ch.tree, "")
+
+	var sc siteConfig
+	if !ch.unmarshal(&sc) {
+		return nil, ch.problems
+	}
+
+	if sc.ID == "" {
+		ch.add("id", ErrSiteNoID)
+	}
+
+	if sc.SenderType == "" {
+		ch.add("sender_type", ErrSiteNoSenderType)
+	} else if err := checkPlugin(sc.SenderType); err != nil {
+		ch.add("sender_type", err)
+	}
+
+	configJSON, err := json.Marshal(sc.SenderConfig)
+	if err != nil {
+		ch.add("sender", fmt.Errorf("error generating config JSON for plugin %s: %w", sc.SenderType, err))
+	}
+
+	webUrls, err := parseWebUrls(sc.WebUrl)
+	if err != nil {
+		ch.add("web_url", err)
+	}
+
+	if sc.RetentionDays < 0 {
+		ch.add("retention_days", ErrInvalidRetentionDays)
+	}
+
+	if sc.AutoReply != nil {
+		if err := sc.AutoReply.check(); err != nil {
+			ch.add("autoreply", err)
 		}
+	}
 
-		if sc.WebUrl == "" {
-			sc.WebUrl = "*"
+	var tmpls *templates.Set
+	if sc.Templates != nil {
+		if tmpls, err = templates.Load(Directory, sc.Templates.Subject, sc.Templates.Text, sc.Templates.HTML); err != nil {
+			ch.add("templates", err)
 		}
+	}
 
-		sitesMap[sc.ID] = &Site{
-			RecaptchaSecret: sc.RecaptchaSecret,
-			WebUrl:          sc.WebUrl,
-			SenderName:      sc.SenderType,
-			ConfigJSON:      string(configJSON),
+	var ms *MailSender
+	if sc.SenderType == MailSenderType {
+		var msc mailSenderConfig
+		if err := ch.tree.Unmarshal(&msc); err != nil {
+			ch.addErr(err)
+		} else if ms, err = msc.parse(); err != nil {
+			ch.add("sender", err)
 		}
 	}
 
-	return sitesMap, nil
+	var enc encryption.Encrypter
+	if sc.Encryption != nil {
+		if enc, err = sc.Encryption.encrypter(); err != nil {
+			ch.add("encryption", err)
+		}
+	}
+
+	if len(ch.problems) != 0 {
+		return nil, ch.problems
+	}
+
+	name, _ := sc.SenderConfig["website_name"].(string)
+	if name == "" {
+		name = sc.ID
+	}
+
+	return &Site{
+		ID:                 sc.ID,
+		Path:               file,
+		Enabled:            sc.Enabled == nil || *sc.Enabled,
+		MaintenanceMessage: sc.Maintenance,
+		Name:               name,
+		RecaptchaSecret:    sc.RecaptchaSecret,
+		WebUrls:            webUrls,
+		Language:           strings.ToLower(sc.Language),
+		RetentionDays:      sc.RetentionDays,
+		AutoReply:          sc.AutoReply,
+		Templates:          tmpls,
+		Mail:               ms,
+		Encrypter:          enc,
+		SenderName:         sc.SenderType,
+		ConfigJSON:         string(configJSON),
+		Profile:            sc.Profile,
+		idLine:             ch.line("id"),
+		effective:          effective,
+	}, nil
+}
+
+// EffectiveConfig returns the config of the site merged with its profiles, in TOML.
+// The references to secrets are not resolved.
+func (s *Site) EffectiveConfig() string {
+	return s.effective
+}
+
+// checkPlugin checks that the plugin of the sender type provided exists in PluginsDirectory
+func checkPlugin(senderType string) error {
+	if senderType != filepath.Base(senderType) || strings.HasPrefix(senderType, ".") {
+		return fmt.Errorf("invalid sender_type %s", senderType)
+	}
+
+	path := filepath.Join(Directory, PluginsDirectory, senderType+PluginExt)
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("invalid sender_type %s: plugin %s not found", senderType, path)
+	}
+	return nil
+}
+
+// AllowedOrigin returns the value of the Access-Control-Allow-Origin header for the origin provided. It will be:
+//
+// - "*" if any origin is allowed.
+//
+// - The origin provided if it matches any of the WebUrls. They can be glob patterns (see path.Match),
+// so "https://*.example.com" will match every subdomain of example.com.
+//
+// - An empty string if the origin is not allowed.
+func (s *Site) AllowedOrigin(origin string) string {
+	for _, webUrl := range s.WebUrls {
+		if webUrl == AnyOrigin {
+			return AnyOrigin
+		}
+		if origin == "" {
+			continue
+		}
+		if matches, _ := path.Match(webUrl, origin); matches {
+			return origin
+		}
+	}
+	return ""
+}
+
+// parseWebUrls parses the web_url value of a site config, that can be a string or a list of strings.
+// It returns a list with AnyOrigin if no web_url is defined. Trailing slashes are removed.
+func parseWebUrls(v interface{}) ([]string, error) {
+	var webUrls []string
+	switch v := v.(type) {
+	case nil:
+	case string:
+		webUrls = append(webUrls, v)
+	case []string:
+		webUrls = append(webUrls, v...)
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, ErrInvalidWebUrl
+			}
+			webUrls = append(webUrls, s)
+		}
+	default:
+		return nil, ErrInvalidWebUrl
+	}
+
+	for i := range webUrls {
+		webUrls[i] = strings.TrimRight(webUrls[i], "/")
+		if _, err := path.Match(webUrls[i], ""); err != nil {
+			return nil, fmt.Errorf("invalid web_url pattern %s: %w", webUrls[i], err)
+		}
+		if webUrls[i] == AnyOrigin {
+			continue
+		}
+		u, err := url.Parse(webUrls[i])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid web_url %s: must be an origin like https://www.example.com", webUrls[i])
+		}
+	}
+
+	if len(webUrls) == 0 {
+		webUrls = []string{AnyOrigin}
+	}
+	return webUrls, nil
 }
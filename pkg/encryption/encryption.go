@@ -0,0 +1,116 @@
+package encryption
+// Package encryption encrypts the messages for the recipients of the sites, so their content can't be read
+// by the servers they transit through.
+
+import (
+	"bytes"
+	"errors"
+	"filippo.io/age"
+	ageArmor "filippo.io/age/armor"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"io"
+	"os"
+
+	// openpgp.Encrypt requires the hash functions preferred by the keys to be available, even if the message
+	// is not signed. Keys without preferences prefer RIPEMD-160.
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+// ErrNoPGPKey is returned when a PGP public key file doesn't contain any key
+var ErrNoPGPKey = errors.New("no PGP public key found")
+
+// Encrypter encrypts messages for a recipient
+type Encrypter interface {
+	// Encrypt returns the plaintext provided encrypted and ASCII armored
+	Encrypt(plaintext []byte) (string, error)
+}
+
+// pgpEncrypter encrypts messages with OpenPGP
+type pgpEncrypter struct {
+	to openpgp.EntityList
+}
+
+// ageEncrypter encrypts messages with age
+type ageEncrypter struct {
+	to []age.Recipient
+}
+
+// LoadPGP returns an Encrypter for the OpenPGP public keys of the file provided,
+// which can be ASCII armored or binary.
+func LoadPGP(path string) (Encrypter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening PGP public key file \"%s\": %w", path, err)
+	}
+	defer f.Close()
+
+	keys, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("error reading PGP public key file \"%s\": %w", path, err)
+		}
+		if keys, err = openpgp.ReadKeyRing(f); err != nil {
+			return nil, fmt.Errorf("error parsing PGP public key file \"%s\": %w", path, err)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoPGPKey
+	}
+	return &pgpEncrypter{to: keys}, nil
+}
+
+// NewAge returns an Encrypter for the age recipient provided (i.e. "age1...")
+func NewAge(recipient string) (Encrypter, error) {
+	r, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing age recipient: %w", err)
+	}
+	return &ageEncrypter{to: []age.Recipient{r}}, nil
+}
+
+// Encrypt returns the plaintext provided encrypted for the PGP keys of e, as a PGP MESSAGE armored block
+func (e *pgpEncrypter) Encrypt(plaintext []byte) (string, error) {
+	buf := new(bytes.Buffer)
+	aw, err := armor.Encode(buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating PGP armor: %w", err)
+	}
+	w, err := openpgp.Encrypt(aw, e.to, nil, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting with PGP: %w", err)
+	}
+	if err := writeClose(w, plaintext); err != nil {
+		return "", fmt.Errorf("error encrypting with PGP: %w", err)
+	}
+	if err := aw.Close(); err != nil {
+		return "", fmt.Errorf("error closing PGP armor: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// Encrypt returns the plaintext provided encrypted for the age recipients of e, as an AGE ENCRYPTED FILE armored block
+func (e *ageEncrypter) Encrypt(plaintext []byte) (string, error) {
+	buf := new(bytes.Buffer)
+	aw := ageArmor.NewWriter(buf)
+	w, err := age.Encrypt(aw, e.to...)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting with age: %w", err)
+	}
+	if err := writeClose(w, plaintext); err != nil {
+		return "", fmt.Errorf("error encrypting with age: %w", err)
+	}
+	if err := aw.Close(); err != nil {
+		return "", fmt.Errorf("error closing age armor: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// writeClose writes the data provided to w and closes it
+func writeClose(w io.WriteCloser, data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,96 @@
+package encryption
+
+import (
+	"filippo.io/age"
+	ageArmor "filippo.io/age/armor"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const plaintext = "Name: John\nEmail: john@example.com\nMessage: Hi!"
+
+func TestPGP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	entity, err := openpgp.NewEntity("Staff", "", "staff@example.com", nil)
+	if err != nil {
+		t.Fatalf("error generating PGP key: %s", err)
+	}
+	keyPath := filepath.Join(dir, "staff.asc")
+	f, err := os.Create(keyPath)
+	if err != nil {
+		t.Fatalf("error creating key file: %s", err)
+	}
+	aw, _ := armor.Encode(f, openpgp.PublicKeyType, nil)
+	if err := entity.Serialize(aw); err != nil {
+		t.Fatalf("error serializing PGP key: %s", err)
+	}
+	aw.Close()
+	f.Close()
+
+	e, err := LoadPGP(keyPath)
+	if err != nil {
+		t.Fatalf("error loading PGP key: %s", err)
+	}
+	ciphertext, err := e.Encrypt([]byte(plaintext))
+	if err != nil {
+		t.Fatalf("error encrypting: %s", err)
+	}
+	if strings.Contains(ciphertext, "John") || !strings.HasPrefix(ciphertext, "-----BEGIN PGP MESSAGE-----") {
+		t.Fatalf("unexpected ciphertext: %s", ciphertext)
+	}
+
+	block, err := armor.Decode(strings.NewReader(ciphertext))
+	if err != nil {
+		t.Fatalf("error decoding armor: %s", err)
+	}
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatalf("error decrypting: %s", err)
+	}
+	decrypted, _ := ioutil.ReadAll(md.UnverifiedBody)
+	if string(decrypted) != plaintext {
+		t.Errorf("Unexpected plaintext:\n-> Expected: %q\n-> Found: %q", plaintext, decrypted)
+	}
+}
+
+func TestAge(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("error generating age identity: %s", err)
+	}
+
+	e, err := NewAge(identity.Recipient().String())
+	if err != nil {
+		t.Fatalf("error parsing age recipient: %s", err)
+	}
+	ciphertext, err := e.Encrypt([]byte(plaintext))
+	if err != nil {
+		t.Fatalf("error encrypting: %s", err)
+	}
+	if strings.Contains(ciphertext, "John") || !strings.HasPrefix(ciphertext, ageArmor.Header) {
+		t.Fatalf("unexpected ciphertext: %s", ciphertext)
+	}
+
+	r, err := age.Decrypt(ageArmor.NewReader(strings.NewReader(ciphertext)), identity)
+	if err != nil {
+		t.Fatalf("error decrypting: %s", err)
+	}
+	decrypted, _ := ioutil.ReadAll(r)
+	if string(decrypted) != plaintext {
+		t.Errorf("Unexpected plaintext:\n-> Expected: %q\n-> Found: %q", plaintext, decrypted)
+	}
+
+	if _, err := NewAge("age1invalid"); err == nil {
+		t.Error("invalid age recipient accepted")
+	}
+}
@@ -0,0 +1,42 @@
+package i18n
+
+import "github.com/Miguel-Dorta/web-msg-handler/api"
+
+// defaults are the catalogs embedded in web-msg-handler.
+// The messages in DefaultLanguage are defined along with the responses, so they're not included here.
+var defaults = Catalogs{
+	"es": {
+		api.CodeNotFound:              "no encontrado",
+		api.CodeMethodNotAllowed:      "método no permitido",
+		api.CodeOriginNotAllowed:      "origen no permitido",
+		api.CodeContentTypeNotAllowed: "Content-Type no permitido",
+		api.CodeMalformedJSON:         "JSON mal formado",
+		api.CodeRequestTooLarge:       "petición demasiado grande",
+		api.CodeValidationFailed:      "validación fallida",
+		api.CodeInvalidEmail:          "email no válido",
+		api.CodeCaptchaRequired:       "se requiere la respuesta del reCAPTCHA",
+		api.CodeCaptchaFailed:         "la verificación del reCAPTCHA ha fallado",
+		api.CodeReadError:             "error desconocido al leer la petición",
+		api.CodeUnknownError:          "error desconocido",
+		api.CodeInternalError:         "error interno del servidor",
+		api.CodeGatewayTimeout:        "tiempo de espera agotado",
+		api.CodeServiceUnavailable:    "servicio no disponible, inténtalo de nuevo más tarde",
+	},
+	"de": {
+		api.CodeNotFound:              "nicht gefunden",
+		api.CodeMethodNotAllowed:      "Methode nicht erlaubt",
+		api.CodeOriginNotAllowed:      "Herkunft nicht erlaubt",
+		api.CodeContentTypeNotAllowed: "Content-Type nicht erlaubt",
+		api.CodeMalformedJSON:         "fehlerhaftes JSON",
+		api.CodeRequestTooLarge:       "Anfrage zu groß",
+		api.CodeValidationFailed:      "Validierung fehlgeschlagen",
+		api.CodeInvalidEmail:          "ungültige E-Mail-Adresse",
+		api.CodeCaptchaRequired:       "reCAPTCHA-Antwort erforderlich",
+		api.CodeCaptchaFailed:         "reCAPTCHA-Überprüfung fehlgeschlagen",
+		api.CodeReadError:             "unbekannter Fehler beim Lesen der Anfrage",
+		api.CodeUnknownError:          "unbekannter Fehler",
+		api.CodeInternalError:         "interner Serverfehler",
+		api.CodeGatewayTimeout:        "Zeitüberschreitung",
+		api.CodeServiceUnavailable:    "Dienst nicht verfügbar, bitte versuche es später erneut",
+	},
+}
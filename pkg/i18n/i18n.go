@@ -0,0 +1,137 @@
+package i18n
+// Package i18n manages the translations of the messages that web-msg-handler replies to the visitors.
+
+import (
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Directory is the subdirectory of config.Directory where the catalogs that override the default ones are saved.
+	// Each file must be named after the language of its catalog (i.e. "es.toml").
+	Directory = "locales"
+
+	// DefaultLanguage is the language of the messages when no catalog matches
+	DefaultLanguage = "en"
+
+	// ext is the extension of the catalog files
+	ext = ".toml"
+)
+
+// Catalog is a set of messages of a language, indexed by their code (see the Code constants of package api).
+type Catalog map[string]string
+
+// Catalogs are the catalogs of every language available, indexed by their language tag in lowercase.
+type Catalogs map[string]Catalog
+
+// Load returns the default catalogs, with the messages of the catalog files found in the directory provided
+// overriding them. New languages can be added the same way. The directory doesn't need to exist.
+func Load(dir string) (Catalogs, error) {
+	cs := make(Catalogs, len(defaults))
+	for lang, c := range defaults {
+		cs[lang] = make(Catalog, len(c))
+		for code, msg := range c {
+			cs[lang][code] = msg
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cs, nil
+		}
+		return nil, fmt.Errorf("error listing locales directory: %w", err)
+	}
+
+	for _, f := range files {
+		if !f.Mode().IsRegular() || filepath.Ext(f.Name()) != ext {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file \"%s\": %w", path, err)
+		}
+
+		var c Catalog
+		if err := toml.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("error parsing catalog from file \"%s\": %w", path, err)
+		}
+
+		lang := strings.ToLower(strings.TrimSuffix(f.Name(), ext))
+		if cs[lang] == nil {
+			cs[lang] = make(Catalog, len(c))
+		}
+		for code, msg := range c {
+			cs[lang][code] = msg
+		}
+	}
+	return cs, nil
+}
+
+// Translate returns the message of the code provided in the language provided,
+// or def if there is no such message.
+func (cs Catalogs) Translate(lang, code, def string) string {
+	if msg, ok := cs[lang][code]; ok {
+		return msg
+	}
+	return def
+}
+
+// Negotiate returns the language that better matches the Accept-Language header provided among the available ones.
+// If none matches, it returns def. Languages with region (i.e. "es-ES") will match their primary language ("es")
+// if there is no catalog for them. DefaultLanguage is always available.
+func (cs Catalogs) Negotiate(acceptLanguage, def string) string {
+	type langQ struct {
+		lang string
+		q    float64
+	}
+
+	var langs []langQ
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		params := strings.Split(part, ";")
+		lang := strings.ToLower(strings.TrimSpace(params[0]))
+		if lang == "" || lang == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, langQ{lang, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+
+	for _, l := range langs {
+		if cs.available(l.lang) {
+			return l.lang
+		}
+		if i := strings.IndexByte(l.lang, '-'); i != -1 && cs.available(l.lang[:i]) {
+			return l.lang[:i]
+		}
+	}
+	return def
+}
+
+// available returns if the language provided have a catalog or it's the DefaultLanguage
+func (cs Catalogs) available(lang string) bool {
+	_, ok := cs[lang]
+	return ok || lang == DefaultLanguage
+}
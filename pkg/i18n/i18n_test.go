@@ -0,0 +1,74 @@
+package i18n_test
+
+import (
+	"github.com/Miguel-Dorta/web-msg-handler/api"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/i18n"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"es.toml": `INVALID_EMAIL = "correo no válido"`,
+		"fr.toml": `INVALID_EMAIL = "e-mail invalide"`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("error writing file %s: %s", name, err)
+		}
+	}
+
+	cs, err := i18n.Load(dir)
+	if err != nil {
+		t.Fatalf("error loading catalogs: %s", err)
+	}
+
+	tests := []struct {
+		lang, code, expected string
+	}{
+		{"es", api.CodeInvalidEmail, "correo no válido"},
+		{"es", api.CodeNotFound, "no encontrado"},
+		{"fr", api.CodeInvalidEmail, "e-mail invalide"},
+		{"fr", api.CodeNotFound, "default"},
+		{"en", api.CodeNotFound, "default"},
+	}
+	for _, test := range tests {
+		if result := cs.Translate(test.lang, test.code, "default"); result != test.expected {
+			t.Errorf("Unexpected translation of %s in %s:\n-> Expected: %s\n-> Found: %s",
+				test.code, test.lang, test.expected, result)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cs, err := i18n.Load("/nonexistent")
+	if err != nil {
+		t.Fatalf("error loading catalogs: %s", err)
+	}
+
+	tests := []struct {
+		acceptLanguage, def, expected string
+	}{
+		{"", "es", "es"},
+		{"de", "es", "de"},
+		{"de-AT", "en", "de"},
+		{"pt-BR, pt;q=0.9", "es", "es"},
+		{"pt-BR, de;q=0.5, en;q=0.8", "es", "en"},
+		{"es;q=0, de", "en", "de"},
+		{"*", "de", "de"},
+	}
+	for _, test := range tests {
+		if result := cs.Negotiate(test.acceptLanguage, test.def); result != test.expected {
+			t.Errorf("Unexpected language for \"%s\" (default %s):\n-> Expected: %s\n-> Found: %s",
+				test.acceptLanguage, test.def, test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is a log file that can be reopened (for example, after being moved by logrotate).
+// It is safe for concurrent use.
+type File struct {
+	path  string
+	f     *os.File
+	mutex sync.Mutex
+}
+
+var (
+	// files are all the files opened with OpenFile
+	files      []*File
+	filesMutex sync.Mutex
+)
+
+// OpenFile opens the log file of the path provided in append mode, creating it if it doesn't exist.
+func OpenFile(path string) (*File, error) {
+	f, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lf := &File{path: path, f: f}
+	filesMutex.Lock()
+	files = append(files, lf)
+	filesMutex.Unlock()
+	return lf, nil
+}
+
+// Write follows the implementation of the io.Writer interface.
+// Writing to a closed File discards the data, so loggers that are being replaced never fail.
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mutex.Lock()
+	defer lf.mutex.Unlock()
+	if lf.f == nil {
+		return len(p), nil
+	}
+	return lf.f.Write(p)
+}
+
+// Reopen closes the file and opens it again in the same path.
+// If the file cannot be opened, it keeps writing to the previous one.
+func (lf *File) Reopen() error {
+	f, err := openLogFile(lf.path)
+	if err != nil {
+		return err
+	}
+
+	lf.mutex.Lock()
+	old := lf.f
+	if old == nil {
+		lf.mutex.Unlock()
+		return f.Close()
+	}
+	lf.f = f
+	lf.mutex.Unlock()
+
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("error closing log file %s: %w", lf.path, err)
+	}
+	return nil
+}
+
+// Close closes the file. It will not be reopened by ReopenFiles anymore.
+func (lf *File) Close() error {
+	filesMutex.Lock()
+	for i, f := range files {
+		if f == lf {
+			files = append(files[:i], files[i+1:]...)
+			break
+		}
+	}
+	filesMutex.Unlock()
+
+	lf.mutex.Lock()
+	defer lf.mutex.Unlock()
+	if lf.f == nil {
+		return nil
+	}
+	err := lf.f.Close()
+	lf.f = nil
+	return err
+}
+
+// ReopenFiles reopens all the files opened with OpenFile.
+// It returns the first error found, but it tries to reopen every file.
+func ReopenFiles() error {
+	filesMutex.Lock()
+	defer filesMutex.Unlock()
+
+	var firstErr error
+	for _, lf := range files {
+		if err := lf.Reopen(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// openLogFile opens the file of the path provided in write-only and append mode, creating it if it doesn't exist.
+func openLogFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening log file %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// closeFiles closes all the files provided. It returns the first error found, but it tries to close every file.
+func closeFiles(files []*File) error {
+	var firstErr error
+	for _, f := range files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
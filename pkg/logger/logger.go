@@ -0,0 +1,299 @@
+package logger
+// Package logger wraps logolang for logging both free text and structured (JSON lines) messages.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Miguel-Dorta/logolang"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// FormatText is the format that prints the messages as logolang does by default
+	FormatText = "text"
+
+	// FormatJSON is the format that prints every message as a JSON object in a single line
+	FormatJSON = "json"
+
+	// FieldRequestID is the field name of the request ID. In FormatText, it is printed as a prefix of the message.
+	FieldRequestID = "request_id"
+)
+
+// ErrInvalidFormat is returned when the log format requested is not supported
+var ErrInvalidFormat = errors.New("invalid log format: must be \"" + FormatText + "\" or \"" + FormatJSON + "\"")
+
+// Fields represents the structured data attached to a log message
+type Fields map[string]interface{}
+
+// Logger is the logger used by web-msg-handler. It is safe for concurrent use,
+// and its output can be replaced while it's being used (see Replace).
+type Logger struct {
+	v atomic.Value
+}
+
+// output is the internal state of a Logger
+type output struct {
+	l     *logolang.Logger
+	json  bool
+	files []*File
+}
+
+// Entry is a log message builder that have some structured data attached.
+type Entry struct {
+	log    *Logger
+	fields Fields
+}
+
+// New creates a new Logger that writes debug and info messages to out, and error and critical messages to err.
+// Both writers must be safe for concurrent use (see logolang.SafeWriter).
+func New(out, err io.Writer, level int, format string) (*Logger, error) {
+	var isJSON bool
+	switch format {
+	case "", FormatText:
+	case FormatJSON:
+		isJSON = true
+	default:
+		return nil, ErrInvalidFormat
+	}
+
+	l := logolang.NewLoggerWriters(out, out, err, err)
+	l.Color = false
+	l.Level = level
+	if isJSON {
+		l.Formatter = jsonFormatter
+	}
+
+	logger := new(Logger)
+	logger.v.Store(&output{l: l, json: isJSON})
+	return logger, nil
+}
+
+// Open creates a new Logger like New, but writing to the files of the paths provided.
+// If outPath or errPath are empty, os.Stdout or os.Stderr will be used respectively.
+// The files opened will be reopened with ReopenFiles and closed with Close.
+func Open(outPath, errPath string, level int, format string) (*Logger, error) {
+	var files []*File
+	out, err := openWriter(outPath, os.Stdout, &files)
+	if err != nil {
+		return nil, err
+	}
+
+	errOut, err := openWriter(errPath, os.Stderr, &files)
+	if err != nil {
+		closeFiles(files)
+		return nil, err
+	}
+
+	l, err := New(out, errOut, level, format)
+	if err != nil {
+		closeFiles(files)
+		return nil, err
+	}
+	l.get().files = files
+	return l, nil
+}
+
+// Replace replaces the output of the Logger with the output of other, closing the files previously opened by it.
+// other must not be used after this call.
+func (l *Logger) Replace(other *Logger) error {
+	old := l.get()
+	l.v.Store(other.get())
+	return closeFiles(old.files)
+}
+
+// Close closes the files opened by the Logger
+func (l *Logger) Close() error {
+	return closeFiles(l.get().files)
+}
+
+// openWriter returns a writer for the file of the path provided, appending it to files,
+// or a writer for def if the path is empty.
+func openWriter(path string, def io.Writer, files *[]*File) (io.Writer, error) {
+	if path == "" {
+		return &logolang.SafeWriter{W: def}, nil
+	}
+
+	f, err := OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	*files = append(*files, f)
+	return f, nil
+}
+
+// get returns the current output of the Logger
+func (l *Logger) get() *output {
+	return l.v.Load().(*output)
+}
+
+// With returns an Entry with the fields provided attached
+func (l *Logger) With(f Fields) *Entry {
+	return &Entry{log: l, fields: f}
+}
+
+// Debug logs a debug message
+func (l *Logger) Debug(msg string) {
+	l.With(nil).Debug(msg)
+}
+
+// Debugf logs a debug message. Arguments are handled in the manner of fmt.Printf.
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.With(nil).Debugf(format, v...)
+}
+
+// Info logs an info message
+func (l *Logger) Info(msg string) {
+	l.With(nil).Info(msg)
+}
+
+// Infof logs an info message. Arguments are handled in the manner of fmt.Printf.
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.With(nil).Infof(format, v...)
+}
+
+// Error logs an error message
+func (l *Logger) Error(msg string) {
+	l.With(nil).Error(msg)
+}
+
+// Errorf logs an error message. Arguments are handled in the manner of fmt.Printf.
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.With(nil).Errorf(format, v...)
+}
+
+// Critical logs a critical message
+func (l *Logger) Critical(msg string) {
+	l.With(nil).Critical(msg)
+}
+
+// Criticalf logs a critical message. Arguments are handled in the manner of fmt.Printf.
+func (l *Logger) Criticalf(format string, v ...interface{}) {
+	l.With(nil).Criticalf(format, v...)
+}
+
+// With returns a copy of the Entry with the fields provided added to the existing ones
+func (e *Entry) With(f Fields) *Entry {
+	fields := make(Fields, len(e.fields)+len(f))
+	for k, v := range e.fields {
+		fields[k] = v
+	}
+	for k, v := range f {
+		fields[k] = v
+	}
+	return &Entry{log: e.log, fields: fields}
+}
+
+// Debug logs a debug message with the fields of the Entry
+func (e *Entry) Debug(msg string) {
+	if o := e.log.get(); o.l.Level >= logolang.LevelDebug {
+		o.l.Debug(e.format(o, msg))
+	}
+}
+
+// Debugf logs a debug message with the fields of the Entry. Arguments are handled in the manner of fmt.Printf.
+func (e *Entry) Debugf(format string, v ...interface{}) {
+	if o := e.log.get(); o.l.Level >= logolang.LevelDebug {
+		o.l.Debug(e.format(o, fmt.Sprintf(format, v...)))
+	}
+}
+
+// Info logs an info message with the fields of the Entry
+func (e *Entry) Info(msg string) {
+	if o := e.log.get(); o.l.Level >= logolang.LevelInfo {
+		o.l.Info(e.format(o, msg))
+	}
+}
+
+// Infof logs an info message with the fields of the Entry. Arguments are handled in the manner of fmt.Printf.
+func (e *Entry) Infof(format string, v ...interface{}) {
+	if o := e.log.get(); o.l.Level >= logolang.LevelInfo {
+		o.l.Info(e.format(o, fmt.Sprintf(format, v...)))
+	}
+}
+
+// Error logs an error message with the fields of the Entry
+func (e *Entry) Error(msg string) {
+	if o := e.log.get(); o.l.Level >= logolang.LevelError {
+		o.l.Error(e.format(o, msg))
+	}
+}
+
+// Errorf logs an error message with the fields of the Entry. Arguments are handled in the manner of fmt.Printf.
+func (e *Entry) Errorf(format string, v ...interface{}) {
+	if o := e.log.get(); o.l.Level >= logolang.LevelError {
+		o.l.Error(e.format(o, fmt.Sprintf(format, v...)))
+	}
+}
+
+// Critical logs a critical message with the fields of the Entry
+func (e *Entry) Critical(msg string) {
+	if o := e.log.get(); o.l.Level >= logolang.LevelCritical {
+		o.l.Critical(e.format(o, msg))
+	}
+}
+
+// Criticalf logs a critical message with the fields of the Entry. Arguments are handled in the manner of fmt.Printf.
+func (e *Entry) Criticalf(format string, v ...interface{}) {
+	if o := e.log.get(); o.l.Level >= logolang.LevelCritical {
+		o.l.Critical(e.format(o, fmt.Sprintf(format, v...)))
+	}
+}
+
+// format returns the message that will be passed to logolang.
+//
+// In FormatJSON, it will be a JSON object with the msg and the fields of the Entry.
+//
+// In FormatText, it will be the msg followed by the fields in "key=value" form, sorted by key.
+func (e *Entry) format(o *output, msg string) string {
+	if o.json {
+		obj := make(map[string]interface{}, len(e.fields)+1)
+		for k, v := range e.fields {
+			obj[k] = v
+		}
+		obj["msg"] = msg
+
+		data, err := json.Marshal(obj)
+		if err != nil {
+			data, _ = json.Marshal(map[string]string{
+				"msg":       msg,
+				"log_error": err.Error(),
+			})
+		}
+		return string(data)
+	}
+
+	sb := new(strings.Builder)
+	if id, ok := e.fields[FieldRequestID]; ok {
+		sb.WriteString(fmt.Sprintf("[Request %v] ", id))
+	}
+	sb.WriteString(msg)
+
+	keys := make([]string, 0, len(e.fields))
+	for k := range e.fields {
+		if k != FieldRequestID {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := fmt.Sprint(e.fields[k])
+		if v == "" || strings.ContainsAny(v, " \"=\n") {
+			v = strconv.Quote(v)
+		}
+		sb.WriteString(" " + k + "=" + v)
+	}
+	return sb.String()
+}
+
+// jsonFormatter is the logolang formatter used in FormatJSON.
+// It receives the message already encoded as a JSON object and adds the timestamp and the level name to it.
+func jsonFormatter(levelName, msg string) string {
+	return fmt.Sprintf(`{"time":"%s","level":"%s",%s`, time.Now().Format(time.RFC3339Nano), levelName, msg[1:])
+}
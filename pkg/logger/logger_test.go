@@ -0,0 +1,65 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/Miguel-Dorta/logolang"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/logger"
+	"strings"
+	"testing"
+)
+
+func TestLoggerJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := &logolang.SafeWriter{W: buf}
+	log, err := logger.New(w, w, logolang.LevelDebug, logger.FormatJSON)
+	if err != nil {
+		t.Fatalf("error creating logger: %s", err)
+	}
+
+	log.With(logger.Fields{logger.FieldRequestID: "abc", "status": 200}).Infof("hello %s", "world")
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		t.Fatalf("output is not a JSON object: %s\n-> Output: %s", err, buf.String())
+	}
+
+	expected := map[string]interface{}{
+		"level":               "INFO",
+		"msg":                 "hello world",
+		logger.FieldRequestID: "abc",
+		"status":              float64(200),
+	}
+	for k, v := range expected {
+		if obj[k] != v {
+			t.Errorf("Unexpected value of field %s:\n-> Expected: %v\n-> Found: %v", k, v, obj[k])
+		}
+	}
+	if _, ok := obj["time"]; !ok {
+		t.Error("time field not found")
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := &logolang.SafeWriter{W: buf}
+	log, err := logger.New(w, w, logolang.LevelInfo, logger.FormatText)
+	if err != nil {
+		t.Fatalf("error creating logger: %s", err)
+	}
+
+	e := log.With(logger.Fields{logger.FieldRequestID: "abc", "outcome": "invalid email", "b": 1})
+	e.Debug("not printed")
+	e.Info("Request finished")
+
+	expected := `INFO: [Request abc] Request finished b=1 outcome="invalid email"`
+	if result := strings.TrimSpace(buf.String()); !strings.HasSuffix(result, expected) {
+		t.Errorf("Unexpected output:\n-> Expected suffix: %s\n-> Found: %s", expected, result)
+	}
+}
+
+func TestNewInvalidFormat(t *testing.T) {
+	if _, err := logger.New(nil, nil, logolang.LevelInfo, "xml"); err != logger.ErrInvalidFormat {
+		t.Errorf("Unexpected error:\n-> Expected: %v\n-> Found: %v", logger.ErrInvalidFormat, err)
+	}
+}
@@ -0,0 +1,114 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-msgauth/dkim"
+	"io/ioutil"
+	"strings"
+)
+
+// DefaultDKIMHeaders are the headers signed by default (see RFC 6376 section 5.4.1)
+var DefaultDKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+var (
+	// ErrDKIMInvalidKey is returned when a DKIM key file doesn't contain a RSA or Ed25519 private key in PEM format
+	ErrDKIMInvalidKey = errors.New("invalid DKIM key: must be a RSA or Ed25519 private key in PEM format")
+
+	// ErrDKIMFromNotSigned is returned when the headers to sign don't include From, as required by RFC 6376
+	ErrDKIMFromNotSigned = errors.New("invalid DKIM headers: From must be signed")
+)
+
+// DKIM is the config used for signing messages with DKIM.
+// They're signed with the relaxed canonicalization for both headers and body.
+type DKIM struct {
+	Domain, Selector string
+	Signer           crypto.Signer
+	Headers          []string
+}
+
+// NewDKIM returns the DKIM config for the domain and selector provided, with the private key of the file provided.
+// If no headers are provided, DefaultDKIMHeaders will be signed.
+func NewDKIM(domain, selector, keyPath string, headers []string) (*DKIM, error) {
+	if len(headers) == 0 {
+		headers = DefaultDKIMHeaders
+	}
+	fromSigned := false
+	for _, h := range headers {
+		if strings.EqualFold(h, "From") {
+			fromSigned = true
+		}
+	}
+	if !fromSigned {
+		return nil, ErrDKIMFromNotSigned
+	}
+
+	signer, err := LoadDKIMKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &DKIM{
+		Domain:   domain,
+		Selector: selector,
+		Signer:   signer,
+		Headers:  headers,
+	}, nil
+}
+
+// LoadDKIMKey reads the private key of the file provided. It must be a PEM encoded RSA key (PKCS #1 or PKCS #8)
+// or Ed25519 key (PKCS #8).
+func LoadDKIMKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading DKIM key file \"%s\": %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrDKIMInvalidKey
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, ErrDKIMInvalidKey
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, ErrDKIMInvalidKey
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, ErrDKIMInvalidKey
+		}
+		return signer, nil
+	}
+	return nil, ErrDKIMInvalidKey
+}
+
+// Sign returns the message data provided with a DKIM-Signature header prepended
+func (d *DKIM) Sign(data []byte) ([]byte, error) {
+	signed := new(bytes.Buffer)
+	err := dkim.Sign(signed, bytes.NewReader(data), &dkim.SignOptions{
+		Domain:                 d.Domain,
+		Selector:               d.Selector,
+		Signer:                 d.Signer,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             d.Headers,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error signing message with DKIM: %w", err)
+	}
+	return signed.Bytes(), nil
+}
@@ -0,0 +1,98 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/emersion/go-msgauth/dkim"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDKIM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %s", err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating Ed25519 key: %s", err)
+	}
+	rsaPub, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	tests := []struct {
+		name, pemType string
+		der           []byte
+		record        string
+	}{
+		{"rsa", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey),
+			"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPub)},
+		{"ed25519", "PRIVATE KEY", edDER,
+			"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub)},
+	}
+
+	m := &Message{
+		From:    Address{Name: "Web", Address: "web@example.com"},
+		To:      []Address{{Address: "staff@example.com"}},
+		ReplyTo: &Address{Name: "Jöhn", Address: "john@example.org"},
+		Subject: "Hello",
+		Text:    "Hi!\nHow are you?",
+		HTML:    "<p>Hi!</p>",
+	}
+	data, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("error composing message: %s", err)
+	}
+
+	for _, test := range tests {
+		keyPath := filepath.Join(dir, test.name+".pem")
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: test.pemType, Bytes: test.der})
+		if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+			t.Fatalf("error writing key: %s", err)
+		}
+
+		d, err := NewDKIM("example.com", test.name, keyPath, nil)
+		if err != nil {
+			t.Fatalf("error loading %s key: %s", test.name, err)
+		}
+		signed, err := d.Sign(data)
+		if err != nil {
+			t.Fatalf("error signing with %s key: %s", test.name, err)
+		}
+
+		opts := &dkim.VerifyOptions{LookupTXT: func(domain string) ([]string, error) {
+			if domain != test.name+"._domainkey.example.com" {
+				t.Errorf("unexpected DKIM domain lookup: %s", domain)
+			}
+			return []string{test.record}, nil
+		}}
+
+		verifications, err := dkim.VerifyWithOptions(bytes.NewReader(signed), opts)
+		if err != nil || len(verifications) != 1 || verifications[0].Err != nil {
+			t.Errorf("invalid %s signature: %v %+v", test.name, err, verifications)
+		}
+
+		tampered := bytes.Replace(signed, []byte("Subject: Hello"), []byte("Subject: Bye"), 1)
+		verifications, err = dkim.VerifyWithOptions(bytes.NewReader(tampered), opts)
+		if err != nil || len(verifications) != 1 || verifications[0].Err == nil {
+			t.Errorf("tampered message verified with %s key", test.name)
+		}
+	}
+
+	if _, err := NewDKIM("example.com", "rsa", filepath.Join(dir, "rsa.pem"), []string{"Subject"}); err != ErrDKIMFromNotSigned {
+		t.Errorf("DKIM without From header accepted: %v", err)
+	}
+}
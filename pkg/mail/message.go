@@ -0,0 +1,156 @@
+package mail
+// Package mail composes the emails of web-msg-handler, and sends the ones that it sends by itself (i.e. auto-replies).
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Address represents an email address with an optional display name
+type Address = mail.Address
+
+// Message represents an email message. It will be a multipart/alternative message if it has an HTML body,
+// and a plain text message otherwise.
+type Message struct {
+	From    Address
+	To      []Address
+	Cc      []Address
+	Bcc     []Address
+	ReplyTo *Address
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Bytes returns the message in RFC 5322 format, ready to be sent.
+// The header values are encoded when needed, and the bodies are encoded in quoted-printable.
+// The Bcc recipients are not included.
+func (m *Message) Bytes() ([]byte, error) {
+	if len(m.To)+len(m.Cc)+len(m.Bcc) == 0 {
+		return nil, fmt.Errorf("message without recipients")
+	}
+
+	buf := new(bytes.Buffer)
+	writeHeader(buf, "From", formatAddress(m.From))
+	if len(m.To) != 0 {
+		writeHeader(buf, "To", joinAddresses(m.To))
+	}
+	if len(m.Cc) != 0 {
+		writeHeader(buf, "Cc", joinAddresses(m.Cc))
+	}
+	if m.ReplyTo != nil {
+		writeHeader(buf, "Reply-To", formatAddress(*m.ReplyTo))
+	}
+	writeHeader(buf, "Subject", mime.QEncoding.Encode("utf-8", removeNewLines(m.Subject)))
+	writeHeader(buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(buf, "Message-ID", messageID(m.From.Address))
+	writeHeader(buf, "MIME-Version", "1.0")
+
+	if m.HTML == "" {
+		writeHeader(buf, "Content-Type", "text/plain; charset=utf-8")
+		writeHeader(buf, "Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(buf, m.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	writeHeader(buf, "Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating message part: %w", err)
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("error closing multipart message: %w", err)
+	}
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// Recipients returns the addresses that the message must be delivered to, including the Cc and Bcc ones
+func (m *Message) Recipients() []string {
+	to := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	for _, addrs := range [][]Address{m.To, m.Cc, m.Bcc} {
+		for _, addr := range addrs {
+			to = append(to, addr.Address)
+		}
+	}
+	return to
+}
+
+// writeHeader writes a header with the key and value provided
+func writeHeader(buf *bytes.Buffer, k, v string) {
+	buf.WriteString(k + ": " + v + "\r\n")
+}
+
+// writeQuotedPrintable writes the string provided to w encoded in quoted-printable
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return fmt.Errorf("error encoding message body: %w", err)
+	}
+	if err := qp.Close(); err != nil {
+		return fmt.Errorf("error encoding message body: %w", err)
+	}
+	return nil
+}
+
+// formatAddress returns the address provided ready to be used in a header.
+// The display name is encoded when needed, and new lines are removed from it.
+func formatAddress(addr Address) string {
+	addr.Name = removeNewLines(addr.Name)
+	addr.Address = removeNewLines(addr.Address)
+	return addr.String()
+}
+
+// joinAddresses returns the addresses provided formatted and separated by commas
+func joinAddresses(addrs []Address) string {
+	formatted := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		formatted = append(formatted, formatAddress(addr))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// messageID returns a new unique Message-ID for the domain of the address provided
+func messageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndexByte(from, '@'); i != -1 {
+		domain = removeNewLines(from[i+1:])
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(id), domain)
+}
+
+// removeNewLines removes the CR and LF characters of the string provided, so it can be used in a header
+func removeNewLines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", " ").Replace(s)
+}
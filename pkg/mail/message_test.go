@@ -0,0 +1,98 @@
+package mail
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestMessageBytes(t *testing.T) {
+	m := &Message{
+		From:    Address{Name: "Web", Address: "web@example.com"},
+		To:      []Address{{Name: "Jöhn", Address: "john@example.org"}},
+		Subject: "Hello\r\nBcc: evil@example.com",
+		Text:    "Hi!",
+	}
+	data, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("error composing message: %s", err)
+	}
+
+	headers := strings.SplitN(string(data), "\r\n\r\n", 2)[0]
+	for _, expected := range []string{
+		"From: \"Web\" <web@example.com>\r\n",
+		"To: =?utf-8?q?J=C3=B6hn?= <john@example.org>\r\n",
+		"Subject: Hello Bcc: evil@example.com\r\n",
+	} {
+		if !strings.Contains(headers+"\r\n", expected) {
+			t.Errorf("header %q not found in:\n%s", expected, headers)
+		}
+	}
+	if strings.Contains(headers, "\r\nBcc:") {
+		t.Error("header injection through the subject")
+	}
+
+	if _, err := (&Message{}).Bytes(); err == nil {
+		t.Error("message without recipients composed")
+	}
+}
+
+func TestMessageMultipart(t *testing.T) {
+	m := &Message{
+		From:    Address{Name: "Web", Address: "web@example.com"},
+		To:      []Address{{Address: "staff@example.com"}},
+		Cc:      []Address{{Address: "cc@example.com"}},
+		Bcc:     []Address{{Address: "bcc@example.com"}},
+		ReplyTo: &Address{Name: "Evil\r\nBcc: evil@example.com", Address: "visitor@example.org"},
+		Subject: "Hello",
+		Text:    "Hi!",
+		HTML:    "<p>Hi!</p>",
+	}
+	data, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("error composing message: %s", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error parsing message: %s", err)
+	}
+	if replyTo, err := msg.Header.AddressList("Reply-To"); err != nil || len(replyTo) != 1 ||
+		replyTo[0].Address != "visitor@example.org" {
+		t.Errorf("unexpected Reply-To: %q (%v)", msg.Header.Get("Reply-To"), err)
+	}
+	if msg.Header.Get("Bcc") != "" {
+		t.Error("Bcc header found")
+	}
+	if cc := msg.Header.Get("Cc"); cc != "<cc@example.com>" {
+		t.Errorf("unexpected Cc: %q", cc)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected Content-Type: %s (%v)", msg.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for _, expected := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "Hi!"},
+		{"text/html; charset=utf-8", "<p>Hi!</p>"},
+	} {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("error reading part: %s", err)
+		}
+		body, _ := ioutil.ReadAll(p)
+		if ct := p.Header.Get("Content-Type"); ct != expected.contentType || string(body) != expected.body {
+			t.Errorf("Unexpected part:\n-> Expected: %s %q\n-> Found: %s %q", expected.contentType, expected.body, ct, body)
+		}
+	}
+
+	recipients := strings.Join(m.Recipients(), ",")
+	if recipients != "staff@example.com,cc@example.com,bcc@example.com" {
+		t.Errorf("unexpected recipients: %s", recipients)
+	}
+}
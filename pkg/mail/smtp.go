@@ -0,0 +1,100 @@
+package mail
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const (
+	// portImplicitTLS is the SMTP port that uses TLS from the beginning of the connection.
+	// Any other port will use STARTTLS when the server supports it.
+	portImplicitTLS = 465
+
+	// timeout is the maximum duration of a SMTP session
+	timeout = 30 * time.Second
+)
+
+// SMTP is the config of the SMTP server used for sending emails
+type SMTP struct {
+	Hostname string `toml:"hostname"`
+	Port     int    `toml:"port"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+}
+
+// Send sends the message provided using the SMTP server provided. from is the address of the envelope sender.
+func Send(s *SMTP, from string, m *Message) error {
+	data, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	return SendRaw(s, from, m.Recipients(), data)
+}
+
+// SendRaw sends the message data provided to the recipients provided using the SMTP server provided.
+// from is the address of the envelope sender.
+func SendRaw(s *SMTP, from string, to []string, data []byte) error {
+	addr := net.JoinHostPort(s.Hostname, strconv.Itoa(s.Port))
+	tlsConfig := &tls.Config{ServerName: s.Hostname}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	dialer := &net.Dialer{Timeout: timeout}
+	if s.Port == portImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to SMTP server %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return fmt.Errorf("error setting SMTP deadline: %w", err)
+	}
+
+	c, err := smtp.NewClient(conn, s.Hostname)
+	if err != nil {
+		return fmt.Errorf("error starting SMTP session with %s: %w", addr, err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && s.Port != portImplicitTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("error starting TLS with %s: %w", addr, err)
+		}
+	}
+
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Hostname)); err != nil {
+			return fmt.Errorf("error authenticating in %s: %w", addr, err)
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return fmt.Errorf("error setting sender %s: %w", from, err)
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("error setting recipient %s: %w", rcpt, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("error starting message data: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("error writing message data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error sending message: %w", err)
+	}
+	return c.Quit()
+}
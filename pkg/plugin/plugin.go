@@ -9,15 +9,15 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"time"
+	"syscall"
 )
 
 const (
 	// Directory is the subdirectory of config.Directory where plugins will be saved
-	Directory = "plugins"
+	Directory = config.PluginsDirectory
 
 	// ext is the extension of the plugins
-	ext       = ".js"
+	ext       = config.PluginExt
 )
 
 // nodePath is the path where is the nodejs executable
@@ -33,15 +33,28 @@ func init() {
 
 // Exec will execute the plugin with the name provided. It requires args and msg being JSON,
 // the first should contain the plugin config (and therefore is up to the plugin creator to define it and check it) and
-// the second will contain 3 fields: "name", "mail" and "msg", all of them strings.
-func Exec(pluginName, args, msg string) error {
+// the second will contain 3 fields: "name", "mail" and "msg", all of them strings. If the site defines templates,
+// it will contain their rendered output in the fields "subject", "text" and "html" too (see package templates).
+// If input is not nil, it will be written to the standard input of the plugin.
+// The plugin will be killed if it doesn't finish before the context provided is done, and the error returned
+// will wrap the error of the context (i.e. context.DeadlineExceeded).
+func Exec(ctx context.Context, pluginName, args, msg string, input []byte) error {
 	pluginName += ext
-	ctx, _ := context.WithTimeout(context.Background(), time.Second*10)
 	stderr := bytes.NewBuffer(nil)
 
 	cmd := exec.CommandContext(ctx, nodePath, filepath.Join(config.Directory, Directory, pluginName), args, msg)
 	cmd.Stderr = stderr
+	// In its own process group, so the signals sent to the group of web-msg-handler (i.e. Ctrl+C)
+	// don't kill it before the graceful shutdown decides
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
 	if err := cmd.Run(); err != nil {
+		// A killed plugin only reports its signal
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("plugin %s killed (%s): %w", pluginName, err, ctxErr)
+		}
 		return fmt.Errorf("error executing plugin %s: %w", pluginName, err)
 	}
 
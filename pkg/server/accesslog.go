@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/logger"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// AccessLogCommon is the Common Log Format
+	AccessLogCommon = "common"
+
+	// AccessLogCombined is the Combined Log Format followed by the latency of the request in seconds
+	AccessLogCombined = "combined"
+
+	// AccessLogJSON is the format that prints a JSON object per request
+	AccessLogJSON = "json"
+
+	// clfTimeFormat is the time format used in the Common and Combined Log Format
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// ErrInvalidAccessLogFormat is returned when the access log format is not one of the supported ones
+var ErrInvalidAccessLogFormat = errors.New("invalid access log format: must be \"" + AccessLogCommon + "\", \"" +
+	AccessLogCombined + "\" or \"" + AccessLogJSON + "\"")
+
+// accessLogOut is the *accessLogOutput currently in use
+var accessLogOut atomic.Value
+
+// accessLogOutput is the file where the access log is written and its format.
+// A nil *accessLogOutput represents a disabled access log.
+type accessLogOutput struct {
+	f      *logger.File
+	format string
+}
+
+// accessLogEntry represents a line of the access log in AccessLogJSON
+type accessLogEntry struct {
+	Time       string `json:"time"`
+	RequestID  string `json:"request_id"`
+	ClientIP   string `json:"client_ip"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	SiteID     string `json:"site_id"`
+	Status     int    `json:"status"`
+	Bytes      int    `json:"bytes"`
+	DurationMS int64  `json:"duration_ms"`
+	Referer    string `json:"referer,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+}
+
+// responseRecorder is a http.ResponseWriter that saves the status code and the number of bytes written
+type responseRecorder struct {
+	http.ResponseWriter
+	status, bytes int
+}
+
+// openAccessLog opens the access log file of the path provided, to be written in the format provided.
+// It returns nil if the path is empty.
+func openAccessLog(path, format string) (*accessLogOutput, error) {
+	switch format {
+	case "":
+		format = AccessLogCommon
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+	default:
+		return nil, ErrInvalidAccessLogFormat
+	}
+
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := logger.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLogOutput{f: f, format: format}, nil
+}
+
+// close closes the access log file, if any
+func (al *accessLogOutput) close() error {
+	if al == nil {
+		return nil
+	}
+	return al.f.Close()
+}
+
+// logAccess returns a handler that wraps next and writes a line for every request in the access log.
+func logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		al := accessLogOut.Load().(*accessLogOutput)
+		if al == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rr := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rr, r)
+		al.write(r, rr, start, time.Since(start))
+	})
+}
+
+// write writes a line in the access log of the request provided
+func (al *accessLogOutput) write(r *http.Request, rr *responseRecorder, start time.Time, duration time.Duration) {
+	var line string
+	switch al.format {
+	case AccessLogJSON:
+		_, siteID := parsePath(r.URL.Path)
+		data, _ := json.Marshal(accessLogEntry{
+			Time:       start.Format(time.RFC3339Nano),
+			RequestID:  rr.Header().Get(headerRequestID),
+			ClientIP:   clientIP(r),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			SiteID:     siteID,
+			Status:     rr.status,
+			Bytes:      rr.bytes,
+			DurationMS: duration.Milliseconds(),
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		})
+		line = string(data)
+	default:
+		bytes := "-"
+		if rr.bytes != 0 {
+			bytes = strconv.Itoa(rr.bytes)
+		}
+		line = fmt.Sprintf("%s - - [%s] %s %d %s",
+			clientIP(r), start.Format(clfTimeFormat),
+			strconv.Quote(r.Method+" "+r.RequestURI+" "+r.Proto), rr.status, bytes)
+		if al.format == AccessLogCombined {
+			line += fmt.Sprintf(" %s %s %.6f", clfQuote(r.Referer()), clfQuote(r.UserAgent()), duration.Seconds())
+		}
+	}
+
+	if _, err := io.WriteString(al.f, line+"\n"); err != nil {
+		log.Errorf("error writing access log: %s", err)
+	}
+}
+
+// WriteHeader follows the implementation of the http.ResponseWriter interface.
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Write follows the implementation of the http.ResponseWriter interface.
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	n, err := rr.ResponseWriter.Write(p)
+	rr.bytes += n
+	return n, err
+}
+
+// clfQuote returns the string provided quoted, or "-" (quoted) if it's empty
+func clfQuote(s string) string {
+	if s == "" {
+		s = "-"
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/admin"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"net"
+	"net/http"
+)
+
+// serveAdmin starts the admin API (see package admin) in the unix socket or address of the config provided,
+// in a new goroutine. It returns nil if the admin API is not enabled. reload is called after every change
+// of the site configs. Unexpected errors that close the server will be sent to errs.
+func serveAdmin(c *config.Config, reload func(), errs chan<- error) (*http.Server, error) {
+	var (
+		ln  net.Listener
+		err error
+	)
+	switch {
+	case c.AdminSocket != "":
+		if ln, err = listen(InheritedAdmin, "unix", c.AdminSocket); err != nil {
+			return nil, err
+		}
+	case c.AdminAddress != "":
+		if ln, err = listen(InheritedAdmin, "tcp", c.AdminAddress); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	srv := &http.Server{
+		Handler: admin.NewHandler(func() string {
+			return getConf().AdminToken
+		}, reload),
+	}
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+	log.Infof("Admin API listening on %s", ln.Addr())
+	return srv, nil
+}
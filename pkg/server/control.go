@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Commands accepted by the control socket (see serveControl)
+const (
+	ControlReload     = "reload"
+	ControlReopenLogs = "reopen-logs"
+	ControlStop       = "stop"
+	ControlUpgrade    = "upgrade"
+)
+
+// controlTimeout is the time that a connection to the control socket can last
+const controlTimeout = 5 * time.Second
+
+// serveControl listens in the unix socket provided for control commands, in a new goroutine.
+// Each connection sends a command in a line, which executes its function of the commands provided,
+// and receives "ok" or "error: <message>". It returns nil if path is empty.
+func serveControl(path string, commands map[string]func()) (net.Listener, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	ln, err := listen(InheritedControl, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Temporary() {
+					continue
+				}
+				return
+			}
+			go handleControl(conn, commands)
+		}
+	}()
+	log.Infof("Control socket listening on %s", path)
+	return ln, nil
+}
+
+// handleControl reads a command from the connection provided, executes it and replies the result
+func handleControl(conn net.Conn, commands map[string]func()) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		// The connections closed without a command only check if this instance is running (i.e. by stop)
+		if err != io.EOF {
+			log.Debugf("error reading control command: %s", err)
+		}
+		return
+	}
+
+	cmd := strings.TrimSpace(line)
+	f, ok := commands[cmd]
+	if !ok {
+		_, _ = fmt.Fprintf(conn, "error: unknown command %q\n", cmd)
+		return
+	}
+	log.Debugf("Control command received: %s", cmd)
+	f()
+	_, _ = fmt.Fprintln(conn, "ok")
+}
+
+// SendControl sends the command provided to the control socket provided, and returns the error replied (if any)
+func SendControl(path, cmd string) error {
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error reading reply: %w", err)
+	}
+	if reply = strings.TrimSpace(reply); reply != "ok" {
+		return errors.New(strings.TrimPrefix(reply, "error: "))
+	}
+	return nil
+}
+
+// listenUnix listens in the unix socket provided, accessible only by its owner.
+// A socket left by a previous execution is removed.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestControl(t *testing.T) {
+	dir := setUpServer(t, `
+log_output_file="%[1]s/out.log"
+log_error_file="%[1]s/err.log"
+`)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "control.sock")
+	reloads := 0
+	ln, err := serveControl(path, map[string]func(){
+		ControlReload: func() { reloads++ },
+	})
+	if err != nil {
+		t.Fatalf("error serving control socket: %s", err)
+	}
+
+	if err := SendControl(path, ControlReload); err != nil {
+		t.Errorf("error sending reload: %s", err)
+	}
+	if err := SendControl(path, "unknown"); err == nil || err.Error() != `unknown command "unknown"` {
+		t.Errorf("unexpected error sending unknown command: %v", err)
+	}
+	if reloads != 1 {
+		t.Errorf("unexpected number of reloads: %d", reloads)
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Errorf("error closing control socket: %s", err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("control socket not removed: %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Errorf("error closing logger: %s", err)
+	}
+}
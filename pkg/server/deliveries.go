@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/archive"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/logger"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/templates"
+	"sync"
+	"time"
+)
+
+var (
+	// inflight are the deliveries in progress (*delivery), so the shutdown can wait for them or abandon them
+	inflight = newInflightSet()
+
+	// autoReplies are the auto-replies being sent (*pendingAutoReply), so the shutdown can wait for them
+	// or log them as abandoned
+	autoReplies = newInflightSet()
+)
+
+// senderKillTimeout is the maximum time to wait for the senders killed when their deliveries are abandoned
+const senderKillTimeout = 5 * time.Second
+
+// delivery is a message being delivered by the sender of its site
+type delivery struct {
+	rLog *logger.Entry
+	site *config.Site
+	meta templates.Request
+	msg  *pluginMsg
+
+	// cancel kills the sender
+	cancel context.CancelFunc
+}
+
+// pendingAutoReply is an auto-reply being sent to the visitor of a request
+type pendingAutoReply struct {
+	rLog *logger.Entry
+	site *config.Site
+}
+
+// inflightSet is a set of tasks in progress (i.e. deliveries), identified by a pointer
+type inflightSet struct {
+	mutex  sync.Mutex
+	active map[interface{}]struct{}
+
+	// running counts the tasks running, including the ones abandoned
+	running sync.WaitGroup
+}
+
+// newInflightSet returns an empty inflightSet
+func newInflightSet() *inflightSet {
+	return &inflightSet{active: make(map[interface{}]struct{})}
+}
+
+// start adds the task provided to the set. It must be called before starting the goroutine that runs it.
+func (s *inflightSet) start(task interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.active[task] = struct{}{}
+	s.running.Add(1)
+}
+
+// finish removes the task provided from the set when it has finished.
+// It returns false if it was abandoned before (see abandon), so its result must be ignored.
+func (s *inflightSet) finish(task interface{}) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.running.Done()
+	if _, ok := s.active[task]; !ok {
+		return false
+	}
+	delete(s.active, task)
+	return true
+}
+
+// wait waits until every task finishes or the context provided is done. It returns false in the last case.
+func (s *inflightSet) wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// snapshot returns the tasks in progress
+func (s *inflightSet) snapshot() []interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tasks := make([]interface{}, 0, len(s.active))
+	for task := range s.active {
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// abandon removes every task from the set and returns them. They must be cancelled by the caller,
+// and they're still counted until they finish.
+func (s *inflightSet) abandon() []interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	abandoned := make([]interface{}, 0, len(s.active))
+	for task := range s.active {
+		delete(s.active, task)
+		abandoned = append(abandoned, task)
+	}
+	return abandoned
+}
+
+// abandonDeliveries abandons the deliveries in progress (see inflightSet.abandon), saving their messages in the archive
+// (even if it's not enabled) so they can be delivered manually, and killing their senders. Every message abandoned is logged.
+func abandonDeliveries() {
+	abandoned := inflight.abandon()
+	if len(abandoned) == 0 {
+		return
+	}
+
+	log.Errorf("Shutdown timeout reached: abandoning %d messages being delivered", len(abandoned))
+	for _, d := range abandoned {
+		d.(*delivery).abandon()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), senderKillTimeout)
+	defer cancel()
+	if !inflight.wait(ctx) {
+		log.Error("Some senders could not be killed")
+	}
+}
+
+// abandon saves the message of the delivery in the archive, logs it and kills its sender
+func (d *delivery) abandon() {
+	defer d.cancel()
+
+	elapsed := time.Since(d.meta.Time).Round(time.Millisecond)
+	r := newRecord(d.site, d.meta, d.msg)
+	r.Outcome, r.SenderError = archive.OutcomeAbandoned, "sender killed by shutdown after "+elapsed.String()
+	if err := archive.Save(r); err != nil {
+		d.rLog.Errorf("Message of site %s abandoned after %s, and it could not be archived: %s", d.site.ID, elapsed, err)
+		return
+	}
+	d.rLog.Errorf("Message of site %s abandoned after %s (it may not have been delivered); archived with ID %d",
+		d.site.ID, elapsed, r.ID)
+}
+
+// abandonAutoReplies logs every auto-reply still being sent, which won't be sent if the process exits.
+// They can't be cancelled, so they're left in the set.
+func abandonAutoReplies() {
+	pending := autoReplies.snapshot()
+	if len(pending) == 0 {
+		return
+	}
+
+	log.Errorf("Shutdown timeout reached: abandoning %d auto-replies being sent", len(pending))
+	for _, task := range pending {
+		a := task.(*pendingAutoReply)
+		a.rLog.Errorf("Auto-reply of site %s abandoned (it may not have been sent)", a.site.ID)
+	}
+}
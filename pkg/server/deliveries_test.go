@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/archive"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/logger"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/templates"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAbandonDeliveries(t *testing.T) {
+	dir := setUpServer(t, `
+log_output_file="%[1]s/out.log"
+log_error_file="%[1]s/err.log"
+`)
+	defer os.RemoveAll(dir)
+
+	site, _ := sites.get("a")
+	newDelivery := func(id string) (*delivery, context.Context) {
+		ctx, cancel := context.WithCancel(context.Background())
+		return &delivery{
+			rLog:   log.With(logger.Fields{"request_id": id}),
+			site:   site,
+			meta:   templates.Request{ID: id, Time: time.Now()},
+			msg:    &pluginMsg{Name: "Name", Mail: "mail@example.com", Msg: "msg " + id},
+			cancel: cancel,
+		}, ctx
+	}
+
+	finished, _ := newDelivery("finished")
+	pending, ctx := newDelivery("pending")
+	inflight.start(finished)
+	inflight.start(pending)
+	if !inflight.finish(finished) {
+		t.Error("finished delivery reported as abandoned")
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if inflight.wait(waitCtx) {
+		t.Fatal("wait returned true with a delivery in progress")
+	}
+
+	go func() {
+		// The sender finishes when it's killed
+		<-ctx.Done()
+		if inflight.finish(pending) {
+			t.Error("abandoned delivery reported as finished")
+		}
+	}()
+	abandonDeliveries()
+	if ctx.Err() == nil {
+		t.Error("sender of the abandoned delivery not cancelled")
+	}
+	if !inflight.wait(context.Background()) {
+		t.Error("wait returned false without deliveries in progress")
+	}
+
+	records, err := archive.List(archive.Filter{})
+	if err != nil {
+		t.Fatalf("error listing archived messages: %s", err)
+	}
+	if len(records) != 1 || records[0].RequestID != "pending" || records[0].Outcome != archive.OutcomeAbandoned ||
+		records[0].Msg != "msg pending" {
+		t.Errorf("unexpected archived messages: %+v", records)
+	}
+
+	if err := log.Close(); err != nil {
+		t.Errorf("error closing logger: %s", err)
+	}
+}
+
+func TestAbandonAutoReplies(t *testing.T) {
+	dir := setUpServer(t, `
+log_output_file="%[1]s/out.log"
+log_error_file="%[1]s/err.log"
+`)
+	defer os.RemoveAll(dir)
+
+	site, _ := sites.get("a")
+	a := &pendingAutoReply{rLog: log.With(logger.Fields{"request_id": "pending"}), site: site}
+	autoReplies.start(a)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if autoReplies.wait(ctx) {
+		t.Fatal("wait returned true with an auto-reply in progress")
+	}
+	abandonAutoReplies()
+
+	autoReplies.finish(a)
+	if !autoReplies.wait(context.Background()) {
+		t.Error("wait returned false without auto-replies in progress")
+	}
+
+	if err := log.Close(); err != nil {
+		t.Errorf("error closing logger: %s", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "err.log"))
+	if err != nil {
+		t.Fatalf("error reading error log: %s", err)
+	}
+	if !strings.Contains(string(data), "Auto-reply of site a abandoned") {
+		t.Errorf("abandoned auto-reply not logged:\n%s", data)
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"html"
+)
+
+// telegramSenderType is the sender_type of the sites whose text is sent as Telegram HTML
+const telegramSenderType = "telegram"
+
+// encryptMsg replaces the message provided with its encrypted version, so the sender and the servers it transits
+// through only know the site it was sent to. The plaintext will be the text rendered from the templates of the site,
+// or the default text if not defined.
+func encryptMsg(site *config.Site, pm *pluginMsg) error {
+	plaintext := pm.Text
+	if plaintext == "" {
+		plaintext = defaultText(site.Name, pm)
+	}
+
+	ciphertext, err := site.Encrypter.Encrypt([]byte(plaintext))
+	if err != nil {
+		return err
+	}
+
+	subject := "Encrypted message from " + site.Name
+	text := subject
+	if site.SenderName == telegramSenderType {
+		text = html.EscapeString(subject)
+	}
+	*pm = pluginMsg{
+		Name:      site.Name,
+		Msg:       ciphertext,
+		Subject:   subject,
+		Text:      text + "\n\n" + ciphertext,
+		encrypted: true,
+	}
+	return nil
+}
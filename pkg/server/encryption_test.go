@@ -0,0 +1,35 @@
+package server
+
+import (
+	"filippo.io/age"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/encryption"
+	"strings"
+	"testing"
+)
+
+func TestEncryptMsg(t *testing.T) {
+	identity, _ := age.GenerateX25519Identity()
+	e, err := encryption.NewAge(identity.Recipient().String())
+	if err != nil {
+		t.Fatalf("error parsing age recipient: %s", err)
+	}
+
+	for _, test := range []struct {
+		senderType, expectedText string
+	}{
+		{"telegram", "Encrypted message from Tom &amp; Jerry &lt;3\n\n"},
+		{config.MailSenderType, "Encrypted message from Tom & Jerry <3\n\n"},
+	} {
+		pm := &pluginMsg{Name: "John", Mail: "john@example.org", Msg: "Hi!"}
+		site := &config.Site{Name: "Tom & Jerry <3", SenderName: test.senderType, Encrypter: e}
+		if err := encryptMsg(site, pm); err != nil {
+			t.Errorf("error encrypting message of %s: %s", test.senderType, err)
+			continue
+		}
+		if !strings.HasPrefix(pm.Text, test.expectedText) || pm.Subject != "Encrypted message from Tom & Jerry <3" ||
+			pm.Name != site.Name {
+			t.Errorf("unexpected encrypted message of %s: %+v", test.senderType, pm)
+		}
+	}
+}
@@ -5,170 +5,509 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/Miguel-Dorta/web-msg-handler/api"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/archive"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/autoreply"
 	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/i18n"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/logger"
 	"github.com/Miguel-Dorta/web-msg-handler/pkg/mime"
 	"github.com/Miguel-Dorta/web-msg-handler/pkg/plugin"
 	"github.com/Miguel-Dorta/web-msg-handler/pkg/recaptcha"
 	"github.com/Miguel-Dorta/web-msg-handler/pkg/sanitation"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/templates"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // responseHeaders are the headers that will be added to every response of web-msg-handler
 var responseHeaders = map[string]string{
-	mime.ContentType:               mime.JSON,
-	"Allow":                        http.MethodOptions + ", " + http.MethodPost,
-	"Cache-Control":                "no-store",
-	"Access-Control-Allow-Headers": mime.ContentType,
-	"Access-Control-Allow-Methods": http.MethodPost,
+	mime.ContentType:                mime.JSON,
+	"Allow":                         http.MethodOptions + ", " + http.MethodPost,
+	"Cache-Control":                 "no-store",
+	"Access-Control-Allow-Headers":  mime.ContentType,
+	"Access-Control-Allow-Methods":  http.MethodPost,
+	"Access-Control-Expose-Headers": headerRequestID,
 }
 
+const (
+	// headerRequestID is the header used for correlating requests
+	headerRequestID = "X-Request-ID"
+
+	// pathPrefixV2 is the path prefix of the requests made to the second version of the API
+	pathPrefixV2 = "/v2/"
+
+	// headerOrigin is the header that contains the origin of a CORS request
+	headerOrigin = "Origin"
+
+	// corsMaxAge is the time in seconds that the clients can cache the response of a preflight request
+	corsMaxAge = 7200
+
+	// maxRequestIDLength is the maximum length of a request ID provided by the client
+	maxRequestIDLength = 128
+
+	// Log field names
+	fieldClientIP   = "client_ip"
+	fieldSiteID     = "site_id"
+	fieldSender     = "sender"
+	fieldOutcome    = "outcome"
+	fieldStatus     = "status"
+	fieldDurationMS = "duration_ms"
+
+	// outcomeSuccess is the outcome logged for successful requests
+	outcomeSuccess = "success"
+)
+
 // handle is the function executed for each HTTP request received by web-msg-handler. It will:
 //
-// - Assign an ID to every request (corresponding to a timestamp of the EPOCH nanosecond when it was received
-// for debugging and logging purposes.
+// - Assign an ID to every request for debugging and logging purposes. It will be the one provided in the
+// X-Request-ID header when valid, or a timestamp of the EPOCH nanosecond when it was received otherwise.
+// That ID will be echoed in the X-Request-ID header of the response.
 //
 // - Check if the Sender ID is correct
 //
-// - Selects a correct handler depending of the method
+// - Check if the origin of the request is allowed by the site (see config.Site.AllowedOrigin)
+//
+// - Selects a correct handler depending of the method. POST requests are rejected if the site is disabled
+// or web-msg-handler is in maintenance (see maintenanceMessage), but CORS preflights are not, so the browsers
+// can read the message.
+//
+// - Log the outcome of the request
 func handle(w http.ResponseWriter, r *http.Request) {
-	// Request ID for logging purposes
-	requestID := time.Now().UnixNano()
-	log.Debugf("[Request %d] Received: %+v", requestID, r)
+	start := time.Now()
+	requestID, ip := getRequestID(r), clientIP(r)
+	w.Header().Set(headerRequestID, requestID)
 
-	siteID := r.URL.Path[1:]
+	rLog := log.With(logger.Fields{
+		logger.FieldRequestID: requestID,
+		fieldClientIP:         ip,
+	})
+	rLog.Debugf("Received %s %s", r.Method, r.URL.Path)
+
+	version, siteID := parsePath(r.URL.Path)
+	c, cs := getConf(), getCatalogs()
+	ri := responseInfo{
+		version:     version,
+		catalogs:    cs,
+		lang:        cs.Negotiate(r.Header.Get("Accept-Language"), i18n.DefaultLanguage),
+		allowOrigin: config.AnyOrigin,
+	}
+	resp := ErrNotFound
 
 	// Check if site exists
-	site, ok := sites[siteID]
-	if !ok {
-		log.Debugf("[Request %d] Site ID not found: %d", requestID, siteID)
-		statusWriter("*", w, ErrNotFound)
-		return
+	if site, ok := sites.get(siteID); !ok {
+		rLog.Debugf("Site ID not found: %s", siteID)
+	} else {
+		origin := r.Header.Get(headerOrigin)
+		ri.allowOrigin = site.AllowedOrigin(origin)
+		if site.Language != "" {
+			ri.lang = cs.Negotiate(r.Header.Get("Accept-Language"), site.Language)
+		}
+		w.Header().Add("Vary", headerOrigin)
+		rLog = rLog.With(logger.Fields{
+			fieldSiteID: siteID,
+			fieldSender: site.SenderName,
+		})
+
+		// Handle depending on http method
+		switch {
+		case r.Method != http.MethodOptions && r.Method != http.MethodPost:
+			rLog.Debugf("Invalid method: %s", r.Method)
+			resp = ErrMethodNotAllowed
+		case origin != "" && ri.allowOrigin == "":
+			rLog.Debugf("Origin not allowed: %s", origin)
+			resp = ErrOriginNotAllowed
+		case r.Method == http.MethodOptions:
+			resp = handleOptions(w, r)
+		case !site.Enabled || c.Maintenance:
+			rLog.Debug("Site unavailable")
+			resp = newUnavailable(maintenanceMessage(site, c))
+		default:
+			resp = handlePost(rLog, c, site, r, templates.Request{
+				ID:        requestID,
+				ClientIP:  ip,
+				UserAgent: r.UserAgent(),
+				Origin:    origin,
+				Referer:   r.Referer(),
+				Language:  ri.lang,
+				Time:      start,
+			})
+		}
 	}
 
-	// Handle depending on http method
-	switch r.Method {
-	case http.MethodOptions:
-		handleOptions(requestID, site, w)
-	case http.MethodPost:
-		handlePost(requestID, site, w, r)
-	default:
-		log.Debugf("[Request %d] Invalid method: %s", requestID, r.Method)
-		statusWriter(site.WebUrl, w, ErrMethodNotAllowed)
-		return
+	statusWriter(ri, w, resp)
+
+	outcome := resp.msg
+	if resp.success {
+		outcome = outcomeSuccess
 	}
+	rLog.With(logger.Fields{
+		fieldOutcome:    outcome,
+		fieldStatus:     resp.status,
+		fieldDurationMS: time.Since(start).Milliseconds(),
+	}).Info("Request finished")
 }
 
-// handleOptions handle the OPTIONS requests
-func handleOptions(requestID int64, site *config.Site, w http.ResponseWriter) {
-	statusWriter(site.WebUrl, w, ResponseOK)
-	log.Debugf("[Request %d] Success", requestID)
+// maintenanceMessage returns the message replied to the visitors when the site provided is disabled or the config
+// provided enables the maintenance. It's the maintenance_message of the site, or the one of the config if not defined.
+// If none is defined, a default translated message is used.
+func maintenanceMessage(site *config.Site, c *config.Config) string {
+	if site.MaintenanceMessage != "" {
+		return site.MaintenanceMessage
+	}
+	return c.MaintenanceMessage
+}
+
+// handleOptions handle the OPTIONS requests (CORS preflight requests).
+// It allows the headers requested and lets the client cache the response for corsMaxAge seconds.
+func handleOptions(w http.ResponseWriter, r *http.Request) *httpResponse {
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+	return ResponseOK
 }
 
 // handlePost handle the POST requests. It:
 // - Check if the Content-Type header is the MIME JSON.
 //
-// - Check if the request body is valid.
+// - Check if the request body is valid and doesn't exceed the size limit.
 //
-// - Check if the email provided is valid.
+// - Check if the fields provided are valid (see validateRequest).
 //
 // - Check if the request have passed the ReCaptcha verification.
 //
-// - Send the message
-func handlePost(requestID int64, site *config.Site, w http.ResponseWriter, r *http.Request) {
+// - Render the templates of the site, if any, with the message and the request metadata provided.
+//
+// - Encrypt the message, if the site has a recipient key (see encryptMsg).
+//
+// - Compose the email, if it's a mail site (see composeMail), and sign it if the site has DKIM.
+//
+// - Send the message, and archive it if enabled (see archiveMsg)
+//
+// - Send the auto-reply to the visitor, if the site has one (see sendAutoReply)
+func handlePost(rLog *logger.Entry, c *config.Config, site *config.Site, r *http.Request, meta templates.Request) *httpResponse {
 	// Check if content-type is valid
 	if contentType := r.Header.Get(mime.ContentType); !strings.Contains(contentType, mime.JSON) {
-		log.Debugf("[Request %d] Invalid content type: %s", requestID, contentType)
-		statusWriter(site.WebUrl, w, ErrContentTypeNotAllowed)
-		return
+		rLog.Debugf("Invalid content type: %s", contentType)
+		return ErrContentTypeNotAllowed
 	}
 
 	// Read body
-	body, err := ioutil.ReadAll(r.Body)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, c.MaxBodySize+1))
 	if err != nil {
-		log.Errorf("[Request %d] Error while reading body: %s", requestID, err)
-		statusWriter(site.WebUrl, w, ErrReadingBody)
-		return
+		rLog.Errorf("Error while reading body: %s", err)
+		return ErrReadingBody
+	}
+	if int64(len(body)) > c.MaxBodySize {
+		rLog.Debugf("Body exceeds the limit of %d bytes", c.MaxBodySize)
+		return ErrRequestTooLarge
 	}
 
 	// Parse body
 	var r2 api.Request
 	if err = json.Unmarshal(body, &r2); err != nil {
-		log.Debugf("[Request %d] Malformed JSON: %s", requestID, err)
-		statusWriter(site.WebUrl, w, ErrMalformedJSON)
-		return
+		rLog.Debugf("Malformed JSON: %s", err)
+		return ErrMalformedJSON
 	}
 
-	// Check valid email
-	if !sanitation.IsValidMail(r2.Mail) {
-		log.Debugf("[Request %d] Invalid email", requestID)
-		statusWriter(site.WebUrl, w, ErrInvalidMail)
-		return
+	if c.LogSensitive {
+		rLog.With(logger.Fields{
+			"name": r2.Name,
+			"mail": r2.Mail,
+			"msg":  r2.Msg,
+		}).Debug("Message received")
 	}
 
-	// Sanitate and serialize input
-	msgJS, err := msgToJSON(sanitation.SanitizeName(r2.Name), r2.Mail, sanitation.SanitizeMsg(r2.Msg))
-	if err != nil {
-		log.Errorf("[Request %d] Error parsing msg to JSON: %s", requestID, err)
-		statusWriter(site.WebUrl, w, ErrUnknown)
-		return
+	// Check valid fields
+	if fields := validateRequest(site, &r2); len(fields) != 0 {
+		for _, f := range fields {
+			rLog.Debugf("Invalid field %s: %s", f.field, f.resp.msg)
+		}
+		return newValidationError(fields)
 	}
 
-	// Check recaptcha
+	// Check recaptcha before doing any work for the message
 	if err = recaptcha.CheckRecaptcha(site.RecaptchaSecret, r2.Recaptcha); err != nil {
-		log.Debugf("[Request %d] Recaptcha verification failed: %s", requestID, err)
-		statusWriter(site.WebUrl, w, ErrRecaptchaVerificationFailed)
-		return
+		rLog.Debugf("Recaptcha verification failed: %s", err)
+		return ErrRecaptchaVerificationFailed
+	}
+
+	// Sanitate input and render templates
+	pm := &pluginMsg{
+		Name: sanitation.SanitizeName(r2.Name),
+		Mail: r2.Mail,
+		Msg:  sanitation.SanitizeMsg(r2.Msg),
+	}
+	if site.Templates != nil {
+		rendered, err := site.Templates.Render(&templates.Data{
+			Name:    pm.Name,
+			Mail:    pm.Mail,
+			Msg:     pm.Msg,
+			Site:    site.ID,
+			Request: meta,
+		})
+		if err != nil {
+			rLog.Errorf("Error rendering templates: %s", err)
+			return ErrInternalServerError
+		}
+		pm.Subject, pm.Text, pm.HTML = rendered.Subject, rendered.Text, rendered.HTML
+	}
+
+	// Encrypt message
+	visitor := autoreply.Data{Name: pm.Name, Mail: pm.Mail, Msg: pm.Msg}
+	if site.Encrypter != nil {
+		if err = encryptMsg(site, pm); err != nil {
+			rLog.Errorf("Error encrypting message: %s", err)
+			return ErrInternalServerError
+		}
+	}
+
+	// Compose email
+	var input []byte
+	if site.Mail != nil {
+		m := composeMail(site.Mail, pm)
+		if input, err = m.Bytes(); err != nil {
+			rLog.Errorf("Error composing email: %s", err)
+			return ErrInternalServerError
+		}
+		if site.Mail.DKIM != nil {
+			if input, err = site.Mail.DKIM.Sign(input); err != nil {
+				rLog.Errorf("Error signing email: %s", err)
+				return ErrInternalServerError
+			}
+		}
+		pm.Envelope = &envelope{From: m.From.Address, To: m.Recipients()}
+	}
+
+	// Serialize input
+	msgJS, err := msgToJSON(pm)
+	if err != nil {
+		rLog.Errorf("Error parsing msg to JSON: %s", err)
+		return ErrUnknown
 	}
 
 	// Exec plugin
-	if err = plugin.Exec(site.SenderName, site.ConfigJSON, msgJS); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.SenderTimeout)*time.Second)
+	defer cancel()
+	d := &delivery{rLog: rLog, site: site, meta: meta, msg: pm, cancel: cancel}
+	inflight.start(d)
+	err = plugin.Exec(ctx, site.SenderName, site.ConfigJSON, msgJS, input)
+	if !inflight.finish(d) {
+		// Abandoned by the shutdown, which archives it
+		return ErrServiceUnavailable
+	}
+	if c.Archive {
+		archiveMsg(rLog, site, meta, pm, err)
+	}
+	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
-			log.Errorf("[Request %d] Sender %s took too long", requestID, site.SenderName)
-			statusWriter(site.WebUrl, w, ErrGatewayTimeout)
-			return
+			rLog.Errorf("Sender %s took too long", site.SenderName)
+			return ErrGatewayTimeout
 		}
 
-		log.Errorf("[Request %d] Sender failed: %s", requestID, err)
-		statusWriter(site.WebUrl, w, ErrInternalServerError)
+		rLog.Errorf("Sender failed: %s", err)
+		return ErrInternalServerError
+	}
+
+	if site.AutoReply != nil {
+		a := &pendingAutoReply{rLog: rLog, site: site}
+		autoReplies.start(a)
+		go func() {
+			defer autoReplies.finish(a)
+			sendAutoReply(rLog, site, visitor)
+		}()
+	}
+	return ResponseOK
+}
+
+// archiveMsg saves the message provided in the archive along with the outcome of its sender, logging any error.
+func archiveMsg(rLog *logger.Entry, site *config.Site, meta templates.Request, pm *pluginMsg, senderErr error) {
+	r := newRecord(site, meta, pm)
+	switch {
+	case errors.Is(senderErr, context.DeadlineExceeded):
+		r.Outcome, r.SenderError = archive.OutcomeTimeout, senderErr.Error()
+	case senderErr != nil:
+		r.Outcome, r.SenderError = archive.OutcomeFailed, senderErr.Error()
+	}
+
+	if err := archive.Save(r); err != nil {
+		rLog.Errorf("Error archiving message: %s", err)
 		return
 	}
+	rLog.Debugf("Message archived with ID %d", r.ID)
+}
+
+// newRecord returns the archive record of the message provided, as it's passed to the sender, with a successful outcome.
+// If it's encrypted, only its ciphertext is recorded, without the name and mail of the visitor.
+func newRecord(site *config.Site, meta templates.Request, pm *pluginMsg) *archive.Record {
+	r := &archive.Record{
+		Time:      meta.Time,
+		SiteID:    site.ID,
+		Name:      pm.Name,
+		Mail:      pm.Mail,
+		Msg:       pm.Msg,
+		ClientIP:  meta.ClientIP,
+		RequestID: meta.ID,
+		Outcome:   archive.OutcomeSuccess,
 
-	statusWriter(site.WebUrl, w, ResponseOK)
-	log.Debugf("[Request %d] Success", requestID)
+		RetentionDays: site.RetentionDays,
+	}
+	if pm.encrypted {
+		r.Name, r.Mail = "", ""
+	}
+	return r
+}
+
+// sendAutoReply sends the auto-reply of the site provided to the visitor, logging the outcome.
+// It's meant to be run in its own goroutine, so the visitor doesn't wait for it.
+func sendAutoReply(rLog *logger.Entry, site *config.Site, d autoreply.Data) {
+	if err := autoreply.Send(site, d); err != nil {
+		if errors.Is(err, autoreply.ErrThrottled) {
+			rLog.Debug("Auto-reply throttled")
+			return
+		}
+		rLog.Errorf("Error sending auto-reply: %s", err)
+		return
+	}
+	rLog.Debug("Auto-reply sent")
+}
+
+// validateRequest checks the fields of the request provided and returns every field that is not valid.
+// It checks that:
+//
+// - The email provided is valid.
+//
+// - The reCAPTCHA response is provided, when the site requires it.
+func validateRequest(site *config.Site, r *api.Request) []fieldError {
+	var fields []fieldError
+	if !sanitation.IsValidMail(r.Mail) {
+		fields = append(fields, fieldError{field: "mail", resp: ErrInvalidMail})
+	}
+	if site.RecaptchaSecret != "" && r.Recaptcha == "" {
+		fields = append(fields, fieldError{field: "g-recaptcha-response", resp: ErrRecaptchaRequired})
+	}
+	return fields
+}
+
+// responseInfo is the information about a request needed to write its response
+type responseInfo struct {
+	// version is the version of the API requested
+	version int
+
+	// catalogs are the catalogs used for translating the messages of the response
+	catalogs i18n.Catalogs
+
+	// lang is the language of the messages of the response
+	lang string
+
+	// allowOrigin is the value of the Access-Control-Allow-Origin header
+	allowOrigin string
 }
 
 // statusWriter will write a response to the http.ResponseWriter provided.
 // That response will be sent with the status code provided,
-// and its body will consists in a JSON represented by api.Response with the success status and error provided.
-func statusWriter(webUrl string, w http.ResponseWriter, resp *httpResponse) {
+// and its body will consists in a JSON represented by api.Response with the success status and error provided,
+// or by api.ResponseV2 if the request was made to the second version of the API, in the language of ri.
+// The headers of responseHeaders will be added unless they were already set, and the Access-Control-Allow-Origin
+// header will be ri.allowOrigin unless it's empty.
+func statusWriter(ri responseInfo, w http.ResponseWriter, resp *httpResponse) {
 	for k, v := range responseHeaders {
-		w.Header().Set(k, v)
+		if w.Header().Get(k) == "" {
+			w.Header().Set(k, v)
+		}
 	}
-	w.Header().Set("Access-Control-Allow-Origin", webUrl)
+	if ri.allowOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", ri.allowOrigin)
+	}
+	w.Header().Set("Content-Language", ri.lang)
+	w.Header().Add("Vary", "Accept-Language")
 	w.WriteHeader(resp.status)
 
-	data, _ := json.Marshal(api.Response{
-		Success: resp.success,
-		Err:     resp.msg,
-	})
+	var data []byte
+	if ri.version == 2 {
+		data, _ = json.Marshal(resp.v2(ri.catalogs, ri.lang))
+	} else {
+		data, _ = json.Marshal(resp.v1(ri.catalogs, ri.lang))
+	}
 
 	if _, err := w.Write(data); err != nil {
 		log.Errorf("error writing response: %s", err)
 	}
 }
 
-// msgToJSON takes the name, mail and msg provided and creates the JSON that will be passed to the site
-func msgToJSON(name, mail, msg string) (string, error) {
-	data, err := json.Marshal(map[string]string{
-		"name": name,
-		"mail": mail,
-		"msg":  msg,
-	})
+// pluginMsg is the message passed to the plugins. Subject, Text and HTML are the result of rendering the templates
+// of the site, and they're omitted when not defined. Envelope is only defined for mail sites, whose composed email
+// is written to the standard input of the plugin.
+type pluginMsg struct {
+	Name    string `json:"name"`
+	Mail    string `json:"mail"`
+	Msg     string `json:"msg"`
+	Subject string `json:"subject,omitempty"`
+	Text    string `json:"text,omitempty"`
+	HTML    string `json:"html,omitempty"`
+
+	Envelope *envelope `json:"envelope,omitempty"`
+
+	// encrypted reports if the message was encrypted (see encryptMsg)
+	encrypted bool
+}
+
+// msgToJSON takes the message provided and creates the JSON that will be passed to the site
+func msgToJSON(pm *pluginMsg) (string, error) {
+	data, err := json.Marshal(pm)
 	if err != nil {
 		return "", err
 	}
 	return string(data), nil
 }
+
+// getRequestID returns the ID of the request provided.
+// It will be the X-Request-ID header if it is valid or the EPOCH nanosecond of the current time otherwise.
+func getRequestID(r *http.Request) string {
+	if id := r.Header.Get(headerRequestID); isValidRequestID(id) {
+		return id
+	}
+	return strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+// isValidRequestID checks if the request ID provided is not empty, not too long, and only contains
+// printable ASCII characters that are not spaces.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientIP returns the IP of the client that made the request provided.
+// It will be the X-Real-IP header set by the reverse proxy if present and the request comes from a trusted proxy
+// (see config.Config.IsTrustedProxy), or the remote address otherwise.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := r.Header.Get("X-Real-IP"); ip != "" && getConf().IsTrustedProxy(net.ParseIP(host)) {
+		return ip
+	}
+	return host
+}
+
+// parsePath returns the API version and the site ID of the request path provided.
+// Paths with the prefix pathPrefixV2 belong to the second version of the API, and any other to the first one.
+func parsePath(path string) (int, string) {
+	if strings.HasPrefix(path, pathPrefixV2) {
+		return 2, path[len(pathPrefixV2):]
+	}
+	return 1, path[1:]
+}
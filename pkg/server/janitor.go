@@ -0,0 +1,68 @@
+package server
+
+import (
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/archive"
+	"time"
+)
+
+// janitorInterval is the time between two runs of the janitor
+const janitorInterval = time.Hour
+
+// runJanitor deletes the expired archived messages now and every janitorInterval, until stop is closed.
+// It's meant to be run in its own goroutine.
+func runJanitor(stop <-chan struct{}) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		deleteExpired(time.Now())
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// deleteExpired deletes the archived messages of every site that are older than its retention days at the time provided.
+// The messages of sites that no longer exist are deleted after the retention days their site had when they were archived.
+func deleteExpired(now time.Time) {
+	current := sites.snapshot()
+	for id, site := range current {
+		if site.RetentionDays == 0 {
+			continue
+		}
+
+		n, err := archive.Delete(archive.Filter{
+			SiteID: id,
+			Before: now.AddDate(0, 0, -site.RetentionDays),
+		})
+		if err != nil {
+			log.Errorf("error deleting expired messages of site %s: %s", id, err)
+			continue
+		}
+		if n != 0 {
+			log.Infof("Deleted %d expired messages of site %s", n, id)
+		}
+	}
+
+	expired, err := archive.List(archive.Filter{Expired: now})
+	if err != nil {
+		log.Errorf("error listing expired messages: %s", err)
+		return
+	}
+	deleted := make(map[string]bool)
+	for _, r := range expired {
+		if _, ok := current[r.SiteID]; ok || deleted[r.SiteID] {
+			continue
+		}
+		deleted[r.SiteID] = true
+
+		n, err := archive.Delete(archive.Filter{SiteID: r.SiteID, Expired: now})
+		if err != nil {
+			log.Errorf("error deleting expired messages of deleted site %s: %s", r.SiteID, err)
+			continue
+		}
+		log.Infof("Deleted %d expired messages of deleted site %s", n, r.SiteID)
+	}
+}
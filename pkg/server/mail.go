@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/mail"
+	"html"
+	"strings"
+)
+
+// envelope is the SMTP envelope of a message composed by web-msg-handler.
+// It's passed to the mail plugin along with the message, which is written to its standard input.
+type envelope struct {
+	From string   `json:"from"`
+	To   []string `json:"to"`
+}
+
+// composeMail composes the email of the message provided for a mail site.
+// It will be sent from the sender address of the site with its display name, to the recipients of the site,
+// and with the visitor as Reply-To. The subject and bodies will be the ones rendered from the templates of the site,
+// or the default ones for those not defined. Encrypted messages (see encryptMsg) only have their text body,
+// and no Reply-To.
+func composeMail(ms *config.MailSender, pm *pluginMsg) *mail.Message {
+	m := &mail.Message{
+		From:    ms.From,
+		To:      []mail.Address{ms.To},
+		Cc:      ms.Cc,
+		Bcc:     ms.Bcc,
+		Subject: pm.Subject,
+		Text:    pm.Text,
+		HTML:    pm.HTML,
+	}
+	if pm.encrypted {
+		return m
+	}
+	m.ReplyTo = &mail.Address{Name: pm.Name, Address: pm.Mail}
+
+	if m.Subject == "" {
+		m.Subject = "Message from " + ms.WebsiteName
+	}
+	if m.Text == "" {
+		m.Text = defaultText(ms.WebsiteName, pm)
+	}
+	if m.HTML == "" {
+		m.HTML = fmt.Sprintf("<html><body>Message from %s<br><br><b>Name:</b> %s<br><b>Email:</b> %s<br>"+
+			"<b>Message:</b><br>%s</body></html>",
+			html.EscapeString(ms.WebsiteName), html.EscapeString(pm.Name), html.EscapeString(pm.Mail),
+			strings.ReplaceAll(html.EscapeString(pm.Msg), "\n", "<br>"))
+	}
+	return m
+}
+
+// defaultText returns the text of the message provided when the site doesn't define a template for it
+func defaultText(websiteName string, pm *pluginMsg) string {
+	return fmt.Sprintf("Message from %s\n\nName: %s\nEmail: %s\nMessage:\n%s", websiteName, pm.Name, pm.Mail, pm.Msg)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"filippo.io/age"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/encryption"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/mail"
+	"strings"
+	"testing"
+)
+
+func TestComposeMail(t *testing.T) {
+	ms := &config.MailSender{
+		From:        mail.Address{Name: "Web", Address: "web@example.com"},
+		To:          mail.Address{Address: "staff@example.com"},
+		WebsiteName: "example.com",
+	}
+	pm := &pluginMsg{Name: "John", Mail: "john@example.org", Msg: "<b>Hi!</b>\nBye"}
+
+	m := composeMail(ms, pm)
+	if m.ReplyTo == nil || m.ReplyTo.Address != pm.Mail {
+		t.Errorf("unexpected Reply-To: %v", m.ReplyTo)
+	}
+	if m.Subject != "Message from example.com" || !strings.Contains(m.Text, pm.Msg) ||
+		!strings.Contains(m.HTML, "&lt;b&gt;Hi!&lt;/b&gt;<br>Bye") {
+		t.Errorf("unexpected message: %+v", m)
+	}
+
+	identity, _ := age.GenerateX25519Identity()
+	e, err := encryption.NewAge(identity.Recipient().String())
+	if err != nil {
+		t.Fatalf("error parsing age recipient: %s", err)
+	}
+	if err := encryptMsg(&config.Site{Name: "example.com", Encrypter: e}, pm); err != nil {
+		t.Fatalf("error encrypting message: %s", err)
+	}
+
+	m = composeMail(ms, pm)
+	if m.ReplyTo != nil || m.HTML != "" || m.Subject != "Encrypted message from example.com" {
+		t.Errorf("unexpected encrypted message: %+v", m)
+	}
+	for _, s := range []string{m.Subject, m.Text, pm.Name, pm.Mail, pm.Msg} {
+		if strings.Contains(s, "John") || strings.Contains(s, "john@example.org") || strings.Contains(s, "Hi!") {
+			t.Errorf("visitor data found in encrypted message: %s", s)
+		}
+	}
+}
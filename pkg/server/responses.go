@@ -1,14 +1,33 @@
 package server
 
 import (
+	"github.com/Miguel-Dorta/web-msg-handler/api"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/i18n"
 	"github.com/Miguel-Dorta/web-msg-handler/pkg/mime"
 	"net/http"
 )
 
+// httpResponse represents a response of web-msg-handler
 type httpResponse struct {
 	status  int
 	success bool
+	code    string
 	msg     string
+
+	// fields are the fields that failed the validation when code is api.CodeValidationFailed
+	fields []fieldError
+
+	// literal reports if msg must not be translated (i.e. it was defined in a config)
+	literal bool
+
+	// legacy is the response sent instead to the clients of the first version of the API, if it didn't have this one
+	legacy *httpResponse
+}
+
+// fieldError represents a field of the request that failed the validation with the response provided
+type fieldError struct {
+	field string
+	resp  *httpResponse
 }
 
 const statusUnknownError = 502
@@ -17,56 +36,160 @@ var (
 	ErrNotFound = &httpResponse{
 		success: false,
 		status:  http.StatusNotFound,
+		code:    api.CodeNotFound,
 		msg:     "not found",
 	}
+	ErrOriginNotAllowed = &httpResponse{
+		success: false,
+		status:  http.StatusForbidden,
+		code:    api.CodeOriginNotAllowed,
+		msg:     "origin not allowed",
+	}
 	ErrMethodNotAllowed = &httpResponse{
 		success: false,
 		status:  http.StatusMethodNotAllowed,
+		code:    api.CodeMethodNotAllowed,
 		msg:     "method not allowed",
 	}
 	ErrContentTypeNotAllowed = &httpResponse{
 		success: false,
 		status:  http.StatusBadRequest,
+		code:    api.CodeContentTypeNotAllowed,
 		msg:     mime.ContentType + " not allowed",
 	}
 	ErrMalformedJSON = &httpResponse{
 		success: false,
 		status:  http.StatusBadRequest,
+		code:    api.CodeMalformedJSON,
 		msg:     "malformed JSON",
 	}
 	ErrInvalidMail = &httpResponse{
 		success: false,
 		status:  http.StatusBadRequest,
+		code:    api.CodeInvalidEmail,
 		msg:     "invalid email",
 	}
+	ErrRequestTooLarge = &httpResponse{
+		success: false,
+		status:  http.StatusRequestEntityTooLarge,
+		code:    api.CodeRequestTooLarge,
+		msg:     "request too large",
+	}
 	ErrRecaptchaVerificationFailed = &httpResponse{
 		success: false,
 		status:  http.StatusBadRequest,
+		code:    api.CodeCaptchaFailed,
 		msg:     "reCAPTCHA verification failed",
 	}
+	ErrRecaptchaRequired = &httpResponse{
+		success: false,
+		status:  http.StatusBadRequest,
+		code:    api.CodeCaptchaRequired,
+		msg:     "reCAPTCHA response required",
+		legacy:  ErrRecaptchaVerificationFailed,
+	}
 	ErrReadingBody = &httpResponse{
 		success: false,
 		status:  statusUnknownError,
+		code:    api.CodeReadError,
 		msg:     "unknown error reading request body",
 	}
 	ErrUnknown = &httpResponse{
 		success: false,
 		status:  statusUnknownError,
+		code:    api.CodeUnknownError,
 		msg:     "unknown error",
 	}
 	ErrInternalServerError = &httpResponse{
 		success: false,
 		status:  http.StatusInternalServerError,
+		code:    api.CodeInternalError,
 		msg:     "internal server error",
 	}
 	ErrGatewayTimeout = &httpResponse{
 		success: false,
 		status:  http.StatusGatewayTimeout,
+		code:    api.CodeGatewayTimeout,
 		msg:     "gateway timeout",
 	}
+	ErrServiceUnavailable = &httpResponse{
+		success: false,
+		status:  http.StatusServiceUnavailable,
+		code:    api.CodeServiceUnavailable,
+		msg:     "service unavailable, please try again later",
+	}
 	ResponseOK = &httpResponse{
 		success: true,
 		status:  http.StatusOK,
 		msg:     "",
 	}
 )
+
+// newValidationError returns the response for a request whose fields provided failed the validation.
+// Clients of the first version of the API will receive the response of the first field.
+func newValidationError(fields []fieldError) *httpResponse {
+	return &httpResponse{
+		success: false,
+		status:  http.StatusBadRequest,
+		code:    api.CodeValidationFailed,
+		msg:     "validation failed",
+		fields:  fields,
+	}
+}
+
+// newUnavailable returns the response for a request to a site that is disabled or in maintenance,
+// with the message provided. If it's empty, ErrServiceUnavailable is returned.
+func newUnavailable(msg string) *httpResponse {
+	if msg == "" {
+		return ErrServiceUnavailable
+	}
+	return &httpResponse{
+		success: false,
+		status:  http.StatusServiceUnavailable,
+		code:    api.CodeServiceUnavailable,
+		msg:     msg,
+		literal: true,
+	}
+}
+
+// v1 returns the content of the response in the format of the first version of the API
+// with its messages in the language provided. The responses that it didn't have are replaced by their legacy ones.
+func (resp *httpResponse) v1(cs i18n.Catalogs, lang string) api.Response {
+	if len(resp.fields) != 0 {
+		return resp.fields[0].resp.v1(cs, lang)
+	}
+	if resp.legacy != nil {
+		return resp.legacy.v1(cs, lang)
+	}
+	return api.Response{
+		Success: resp.success,
+		Err:     resp.localizedMsg(cs, lang),
+	}
+}
+
+// v2 returns the content of the response in the format of the second version of the API
+// with its messages in the language provided
+func (resp *httpResponse) v2(cs i18n.Catalogs, lang string) api.ResponseV2 {
+	r := api.ResponseV2{
+		Success: resp.success,
+		Code:    resp.code,
+		Err:     resp.localizedMsg(cs, lang),
+	}
+	for _, f := range resp.fields {
+		r.Fields = append(r.Fields, api.FieldError{
+			Field: f.field,
+			Code:  f.resp.code,
+			Err:   f.resp.localizedMsg(cs, lang),
+		})
+	}
+	return r
+}
+
+// localizedMsg returns the message of the response in the language provided, or in i18n.DefaultLanguage
+// if it's not available
+func (resp *httpResponse) localizedMsg(cs i18n.Catalogs, lang string) string {
+	if resp.msg == "" || resp.literal {
+		return resp.msg
+	}
+	return cs.Translate(lang, resp.code, resp.msg)
+}
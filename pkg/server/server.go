@@ -3,76 +3,342 @@ package server
 
 import (
 	"context"
-	"github.com/Miguel-Dorta/logolang"
+	"errors"
 	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/i18n"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/logger"
 	"golang.org/x/sys/unix"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 var (
-	log   *logolang.Logger
-	sites map[string]*config.Site
+	log   *logger.Logger
+	sites siteRegistry
+
+	// conf is the *config.Config currently applied
+	conf atomic.Value
+
+	// catalogs are the i18n.Catalogs currently loaded
+	catalogs atomic.Value
 )
 
-// Run will start a HTTP server in the port provided using the logger provided.
-// It ends when a SIGTERM or SIGINT is received.
+// Run will start a HTTP server with the config provided using the logger provided.
+// It reloads the config and the site configs when a SIGUSR1 is received (see reloadConfig) or the admin API
+// changes the site configs (see serveAdmin),
+// and reopens the log files when a SIGHUP is received. When a SIGUSR2 is received, it starts a new instance that takes
+// its listeners and PID file (see upgradeInstance), and shuts down when it's ready. These actions can be requested
+// through the control socket too (see serveControl).
+// The listeners inherited from a previous instance are used instead of new ones (see listen).
+// The archived messages of the sites are deleted after their retention days (see runJanitor).
+// It ends when a SIGTERM or SIGINT is received, waiting for the requests and deliveries in progress (see shutdown).
 // It can end the program execution prematurely.
-func Run(port int, logger *logolang.Logger) {
-	log = logger
-	err := loadSites()
+func Run(c *config.Config, l *logger.Logger) {
+	log = l
+	al, err := openAccessLog(c.AccessLogFile, c.AccessLogFormat)
+	if err != nil {
+		log.Criticalf("error loading access log: %s", err)
+		os.Exit(1)
+	}
+	accessLogOut.Store(al)
+	conf.Store(c)
+
+	s, err := config.LoadSites()
 	if err != nil {
 		log.Criticalf("error loading sites config: %s", err)
 		os.Exit(1)
 	}
+	sites.swap(s)
 
-	http.HandleFunc("/", handle)
-	srv := http.Server{Addr: ":" + strconv.Itoa(port)}
+	cs, err := loadCatalogs()
+	if err != nil {
+		log.Criticalf("error loading locales: %s", err)
+		os.Exit(1)
+	}
+	catalogs.Store(cs)
 
-	serverClosed := make(chan bool)
-	go func() {
-		var (
-			quit = make(chan os.Signal, 2)
-			reload = make(chan os.Signal, 1)
-		)
-		signal.Notify(quit, unix.SIGTERM, unix.SIGINT)
-		signal.Notify(reload, unix.SIGUSR1)
-		defer close(serverClosed)
-
-		for {
-			select {
-			case <-reload:
-				if err := loadSites(); err != nil {
-					log.Errorf("error reloading sites config: %s", err)
-					log.Info("preserving previous config")
-				}
-			case <-quit:
-				log.Info("Shutting down")
-				if err := srv.Shutdown(context.Background()); err != nil {
-					log.Criticalf("error while shutting down: %s", err)
-					os.Exit(1)
-				}
-				return
+	serveErrs := make(chan error, 1)
+	srv, err := serve(c.Port, serveErrs)
+	if err != nil {
+		log.Criticalf("error listening port %d: %s", c.Port, err)
+		os.Exit(1)
+	}
+
+	stopJanitor := make(chan struct{})
+	defer close(stopJanitor)
+	go runJanitor(stopJanitor)
+
+	var (
+		quit    = make(chan os.Signal, 2)
+		reload  = make(chan os.Signal, 1)
+		reopen  = make(chan os.Signal, 1)
+		upgrade = make(chan os.Signal, 1)
+	)
+
+	adminSrv, err := serveAdmin(c, notify(reload, unix.SIGUSR1), serveErrs)
+	if err != nil {
+		log.Criticalf("error starting admin API: %s", err)
+		os.Exit(1)
+	}
+
+	ctl, err := serveControl(c.ControlSocket, map[string]func(){
+		ControlReload:     notify(reload, unix.SIGUSR1),
+		ControlReopenLogs: notify(reopen, unix.SIGHUP),
+		ControlStop:       notify(quit, unix.SIGTERM),
+		ControlUpgrade:    notify(upgrade, unix.SIGUSR2),
+	})
+	if err != nil {
+		log.Criticalf("error starting control socket: %s", err)
+		os.Exit(1)
+	}
+	if ctl != nil {
+		// It's closed the last, so the commands that stop this instance can wait for the socket to be removed
+		defer ctl.Close()
+	}
+
+	signal.Notify(quit, unix.SIGTERM, unix.SIGINT)
+	signal.Notify(reload, unix.SIGUSR1)
+	signal.Notify(reopen, unix.SIGHUP)
+	signal.Notify(upgrade, unix.SIGUSR2)
+	notifyReady()
+
+	for {
+		select {
+		case <-reload:
+			srv = reloadConfig(srv, serveErrs)
+		case <-reopen:
+			if err := logger.ReopenFiles(); err != nil {
+				log.Errorf("error reopening log files: %s", err)
 			}
+		case err := <-serveErrs:
+			log.Criticalf("Unexpected error which closed the server: %s", err)
+			os.Exit(1)
+		case <-upgrade:
+			pid, err := upgradeInstance()
+			if err != nil {
+				log.Errorf("error upgrading: %s", err)
+				continue
+			}
+			log.Infof("Upgraded to a new instance with PID %d. Shutting down", pid)
+			// The sockets are used by the new instance, and the control commands must be received by it
+			keepSockets()
+			if ctl != nil {
+				_ = ctl.Close()
+			}
+			shutdown(srv, adminSrv, time.Duration(getConf().ShutdownTimeout)*time.Second)
+			return
+		case <-quit:
+			log.Info("Shutting down")
+			shutdown(srv, adminSrv, time.Duration(getConf().ShutdownTimeout)*time.Second)
+			return
 		}
+	}
+}
+
+// shutdown shuts down gracefully the servers provided (adminSrv can be nil): they stop accepting connections,
+// and the requests, deliveries and auto-replies in progress are waited until the timeout provided.
+// Then, the deliveries that didn't finish are abandoned (see abandonDeliveries) and the connections are closed,
+// and the auto-replies still being sent are logged (see abandonAutoReplies).
+// It can end the program execution prematurely.
+func shutdown(srv, adminSrv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	adminDone := make(chan struct{})
+	go func() {
+		defer close(adminDone)
+		if adminSrv == nil {
+			return
+		}
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			log.Errorf("error while shutting down admin API: %s", err)
+		}
+	}()
+	// Its socket is removed when it's closed
+	defer func() {
+		<-adminDone
 	}()
 
-	log.Infof("Listening port %s", srv.Addr[1:])
-	if err = srv.ListenAndServe(); err != http.ErrServerClosed {
-		log.Criticalf("Unexpected error which closed the server: %s", err)
+	err := srv.Shutdown(ctx)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		log.Criticalf("error while shutting down: %s", err)
 		os.Exit(1)
 	}
-	<-serverClosed
+
+	// The handlers finish before their deliveries only when they're abandoned, but they're waited anyway
+	// in case the server is not the one that started them (i.e. after changing the port).
+	if err != nil || !inflight.wait(ctx) {
+		abandonDeliveries()
+		if err := srv.Close(); err != nil {
+			log.Errorf("error closing connections: %s", err)
+		}
+	}
+
+	// The auto-replies are sent after their deliveries, so they're waited until the same deadline
+	if !autoReplies.wait(ctx) {
+		abandonAutoReplies()
+	}
+}
+
+// notify returns a function that sends the signal provided to the channel provided, unless it's full
+func notify(ch chan<- os.Signal, sig os.Signal) func() {
+	return func() {
+		select {
+		case ch <- sig:
+		default:
+		}
+	}
+}
+
+// serve starts a HTTP server listening in the port provided in a new goroutine.
+// Unexpected errors that close the server will be sent to errs.
+func serve(port int, errs chan<- error) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:    ":" + strconv.Itoa(port),
+		Handler: logAccess(http.HandlerFunc(handle)),
+	}
+
+	ln, err := listen(InheritedPort, "tcp", srv.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+	log.Infof("Listening port %d", port)
+	return srv, nil
 }
 
-// loadSites loads the site configs and sets it to the package variable "sites"
-func loadSites() error {
+// reloadConfig reads the config and the site configs again and applies them. It can change:
+//
+// - The log level, format and files.
+//
+// - The access log format and file.
+//
+// - The request limits and timeouts.
+//
+// - The port, starting to listen in the new one and shutting down gracefully the server provided.
+//
+// - The site configs.
+//
+// - The locales.
+//
+// The settings that cannot be changed while running are reported. If any config cannot be loaded,
+// the previous one is preserved. It returns the server that is listening after the reload.
+func reloadConfig(srv *http.Server, serveErrs chan<- error) *http.Server {
+	old := getConf()
+	c, err := config.Load()
+	if err != nil {
+		log.Errorf("error reloading config: %s", err)
+		log.Info("preserving previous config")
+		return srv
+	}
+
+	newLog, err := logger.Open(c.LogOutFile, c.LogErrFile, c.Verbose, c.LogFormat)
+	if err != nil {
+		log.Errorf("error reloading log config: %s", err)
+		log.Info("preserving previous config")
+		return srv
+	}
+
+	al, err := openAccessLog(c.AccessLogFile, c.AccessLogFormat)
+	if err != nil {
+		_ = newLog.Close()
+		log.Errorf("error reloading access log config: %s", err)
+		log.Info("preserving previous config")
+		return srv
+	}
+
 	s, err := config.LoadSites()
 	if err != nil {
-		return err
+		_ = newLog.Close()
+		_ = al.close()
+		log.Errorf("error reloading sites config: %s", err)
+		log.Info("preserving previous config")
+		return srv
 	}
-	sites = s
-	return nil
+
+	cs, err := loadCatalogs()
+	if err != nil {
+		_ = newLog.Close()
+		_ = al.close()
+		log.Errorf("error reloading locales: %s", err)
+		log.Info("preserving previous config")
+		return srv
+	}
+
+	if c.Port != old.Port {
+		newSrv, err := serve(c.Port, serveErrs)
+		if err != nil {
+			log.Errorf("error listening port %d: %s", c.Port, err)
+			log.Infof("keep listening port %d", old.Port)
+			c.Port = old.Port
+		} else {
+			go func(srv *http.Server) {
+				if err := srv.Shutdown(context.Background()); err != nil {
+					log.Errorf("error shutting down server of port %d: %s", old.Port, err)
+				}
+			}(srv)
+			srv = newSrv
+		}
+	}
+
+	if err := log.Replace(newLog); err != nil {
+		log.Errorf("error closing previous log files: %s", err)
+	}
+	if err := accessLogOut.Load().(*accessLogOutput).close(); err != nil {
+		log.Errorf("error closing previous access log: %s", err)
+	}
+	accessLogOut.Store(al)
+	conf.Store(c)
+	sites.swap(s)
+	catalogs.Store(cs)
+
+	for _, setting := range restartRequired(old, c) {
+		log.Infof("setting %s changed, but it requires a restart to be applied", setting)
+	}
+	log.Info("Config reloaded")
+	return srv
+}
+
+// restartRequired returns the names of the settings that changed between the configs provided
+// that cannot be applied without a restart.
+func restartRequired(old, c *config.Config) []string {
+	var settings []string
+	if old.PIDFile != c.PIDFile {
+		settings = append(settings, "pid_file")
+	}
+	if old.AdminAddress != c.AdminAddress {
+		settings = append(settings, "admin_address")
+	}
+	if old.AdminSocket != c.AdminSocket {
+		settings = append(settings, "admin_socket")
+	}
+	if old.ControlSocket != c.ControlSocket {
+		settings = append(settings, "control_socket")
+	}
+	return settings
+}
+
+// loadCatalogs loads the default catalogs overridden by the ones of the locales directory
+func loadCatalogs() (i18n.Catalogs, error) {
+	return i18n.Load(filepath.Join(config.Directory, i18n.Directory))
+}
+
+// getCatalogs returns the catalogs currently loaded
+func getCatalogs() i18n.Catalogs {
+	return catalogs.Load().(i18n.Catalogs)
+}
+
+// getConf returns the config currently applied
+func getConf() *config.Config {
+	return conf.Load().(*config.Config)
 }
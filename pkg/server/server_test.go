@@ -0,0 +1,494 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/archive"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/logger"
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/templates"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setUpServer creates a config directory in a temporary directory, loads it like Run does, and returns its path.
+func setUpServer(t *testing.T, configTOML string) string {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	config.Directory = dir
+
+	if err := os.Mkdir(filepath.Join(dir, config.SitesDirectory), 0755); err != nil {
+		t.Fatalf("error creating sites directory: %s", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, config.PluginsDirectory), 0755); err != nil {
+		t.Fatalf("error creating plugins directory: %s", err)
+	}
+	writeFile(t, filepath.Join(dir, config.PluginsDirectory, "none"+config.PluginExt), "")
+	writeFile(t, filepath.Join(dir, config.Filename), fmt.Sprintf(configTOML, dir))
+	writeSite(t, dir, "a", `"https://a0.com"`)
+	writeSite(t, dir, "b", `"*"`)
+
+	c, err := config.Load()
+	if err != nil {
+		t.Fatalf("error loading config: %s", err)
+	}
+
+	log, err = logger.Open(c.LogOutFile, c.LogErrFile, c.Verbose, c.LogFormat)
+	if err != nil {
+		t.Fatalf("error opening logger: %s", err)
+	}
+
+	al, err := openAccessLog(c.AccessLogFile, c.AccessLogFormat)
+	if err != nil {
+		t.Fatalf("error opening access log: %s", err)
+	}
+	accessLogOut.Store(al)
+	conf.Store(c)
+
+	s, err := config.LoadSites()
+	if err != nil {
+		t.Fatalf("error loading sites: %s", err)
+	}
+	sites.swap(s)
+
+	cs, err := loadCatalogs()
+	if err != nil {
+		t.Fatalf("error loading locales: %s", err)
+	}
+	catalogs.Store(cs)
+	return dir
+}
+
+// writeSite writes atomically the config of a site with the ID and web_url (in TOML) provided.
+func writeSite(t *testing.T, dir, id, webUrl string) {
+	tmpPath := filepath.Join(dir, id+".tmp")
+	writeFile(t, tmpPath, fmt.Sprintf("id=%q\nsender_type=\"none\"\nweb_url=%s\n", id, webUrl))
+	if err := os.Rename(tmpPath, filepath.Join(dir, config.SitesDirectory, id+".toml")); err != nil {
+		t.Fatalf("error renaming site config: %s", err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("error writing file %s: %s", path, err)
+	}
+}
+
+// TestReloadDuringRequests reloads the config continuously while requests are being handled.
+// It's meant to be run with the race detector (go test -race).
+func TestReloadDuringRequests(t *testing.T) {
+	const (
+		reloads = 200
+		clients = 8
+	)
+
+	dir := setUpServer(t, `
+verbose=4
+log_format="json"
+log_output_file="%[1]s/out.log"
+log_error_file="%[1]s/err.log"
+access_log_file="%[1]s/access.log"
+`)
+	defer os.RemoveAll(dir)
+	handler := logAccess(http.HandlerFunc(handle))
+
+	var (
+		wg   sync.WaitGroup
+		stop = make(chan struct{})
+		errs = make(chan string, clients)
+	)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				r := httptest.NewRequest(http.MethodPost, "/a", strings.NewReader(`{"mail": "invalid"}`))
+				r.Header.Set("Content-Type", "application/json")
+				r.Header.Set("Origin", "https://a0.com")
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+
+				if w.Code != http.StatusBadRequest {
+					errs <- fmt.Sprintf("unexpected status code: %d", w.Code)
+					return
+				}
+				if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "https://a0.com" {
+					errs <- fmt.Sprintf("unexpected origin: %s", origin)
+					return
+				}
+			}
+		}()
+	}
+
+	serveErrs := make(chan error, 1)
+	for i := 1; i <= reloads; i++ {
+		writeSite(t, dir, "a", fmt.Sprintf(`["https://*.com", "https://a%d.org"]`, i))
+		reloadConfig(nil, serveErrs)
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if origin := sites.snapshot()["a"].WebUrls[1]; origin != fmt.Sprintf("https://a%d.org", reloads) {
+		t.Errorf("last reload not applied: found origin %s", origin)
+	}
+	if err := log.Close(); err != nil {
+		t.Errorf("error closing logger: %s", err)
+	}
+}
+
+// TestReloadPreservesConfig checks that an invalid config doesn't replace the one being used.
+func TestReloadPreservesConfig(t *testing.T) {
+	dir := setUpServer(t, `
+verbose=4
+log_output_file="%[1]s/out.log"
+log_error_file="%[1]s/err.log"
+`)
+	defer os.RemoveAll(dir)
+	old := getConf()
+
+	writeFile(t, filepath.Join(dir, config.Filename), `log_format="xml"`)
+	reloadConfig(nil, nil)
+	if getConf() != old {
+		t.Error("invalid config was applied")
+	}
+
+	writeFile(t, filepath.Join(dir, config.SitesDirectory, "c.toml"), `id="a"`)
+	reloadConfig(nil, nil)
+	if getConf() != old {
+		t.Error("config with invalid sites was applied")
+	}
+	if _, ok := sites.get("a"); !ok {
+		t.Error("previous sites were not preserved")
+	}
+
+	if err := log.Close(); err != nil {
+		t.Errorf("error closing logger: %s", err)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	dir := setUpServer(t, `
+log_output_file="%[1]s/out.log"
+log_error_file="%[1]s/err.log"
+`)
+	defer os.RemoveAll(dir)
+	writeSite(t, dir, "c", `["https://www.c.com/", "https://*.c.org"]`)
+	reloadConfig(nil, nil)
+
+	tests := []struct {
+		site, method, origin string
+		status               int
+		allowOrigin          string
+	}{
+		{"c", http.MethodOptions, "https://www.c.com", http.StatusOK, "https://www.c.com"},
+		{"c", http.MethodOptions, "https://staging.c.org", http.StatusOK, "https://staging.c.org"},
+		{"c", http.MethodOptions, "https://c.com", http.StatusForbidden, ""},
+		{"c", http.MethodPost, "https://evil.com", http.StatusForbidden, ""},
+		{"c", http.MethodPost, "https://www.c.com", http.StatusBadRequest, "https://www.c.com"},
+		{"b", http.MethodPost, "https://evil.com", http.StatusBadRequest, "*"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/"+test.site, strings.NewReader(`{"mail": "invalid"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Origin", test.origin)
+		r.Header.Set("Access-Control-Request-Headers", "content-type,x-request-id")
+		w := httptest.NewRecorder()
+		handle(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("Unexpected status of %s %s from %s:\n-> Expected: %d\n-> Found: %d",
+				test.method, test.site, test.origin, test.status, w.Code)
+		}
+		if allowOrigin := w.Header().Get("Access-Control-Allow-Origin"); allowOrigin != test.allowOrigin {
+			t.Errorf("Unexpected allowed origin of %s %s from %s:\n-> Expected: %s\n-> Found: %s",
+				test.method, test.site, test.origin, test.allowOrigin, allowOrigin)
+		}
+		if test.method == http.MethodOptions && test.status == http.StatusOK {
+			if headers := w.Header().Get("Access-Control-Allow-Headers"); headers != "content-type,x-request-id" {
+				t.Errorf("Unexpected allowed headers: %s", headers)
+			}
+			if w.Header().Get("Access-Control-Max-Age") == "" {
+				t.Error("Access-Control-Max-Age not found")
+			}
+		}
+	}
+
+	if err := log.Close(); err != nil {
+		t.Errorf("error closing logger: %s", err)
+	}
+}
+
+func TestAPIVersions(t *testing.T) {
+	dir := setUpServer(t, `
+log_output_file="%[1]s/out.log"
+log_error_file="%[1]s/err.log"
+`)
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, config.SitesDirectory, "d.toml"), `
+id="d"
+sender_type="none"
+recaptcha_secret="secret"
+`)
+	reloadConfig(nil, nil)
+
+	writeFile(t, filepath.Join(dir, config.SitesDirectory, "e.toml"), `
+id="e"
+sender_type="none"
+language="es"
+`)
+	reloadConfig(nil, nil)
+
+	tests := []struct {
+		path, acceptLanguage, body, expected string
+	}{
+		{"/d", "", "", `{"success":false,"error":"invalid email"}`},
+		{"/d", "", `{"mail": "mail@example.com"}`, `{"success":false,"error":"reCAPTCHA verification failed"}`},
+		{"/v2/d", "", `{"mail": "mail@example.com"}`, `{"success":false,"code":"VALIDATION_FAILED",` +
+			`"error":"validation failed","fields":[` +
+			`{"field":"g-recaptcha-response","code":"CAPTCHA_REQUIRED","error":"reCAPTCHA response required"}]}`},
+		{"/d", "de-DE,de;q=0.9", "", `{"success":false,"error":"ungültige E-Mail-Adresse"}`},
+		{"/e", "", "", `{"success":false,"error":"email no válido"}`},
+		{"/e", "pt-BR, en;q=0.5", "", `{"success":false,"error":"invalid email"}`},
+		{"/v2/d", "", "", `{"success":false,"code":"VALIDATION_FAILED","error":"validation failed","fields":[` +
+			`{"field":"mail","code":"INVALID_EMAIL","error":"invalid email"},` +
+			`{"field":"g-recaptcha-response","code":"CAPTCHA_REQUIRED","error":"reCAPTCHA response required"}]}`},
+		{"/v2/unknown", "", "", `{"success":false,"code":"NOT_FOUND","error":"not found"}`},
+	}
+
+	for _, test := range tests {
+		if test.body == "" {
+			test.body = `{"mail": "invalid"}`
+		}
+		r := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept-Language", test.acceptLanguage)
+		w := httptest.NewRecorder()
+		handle(w, r)
+
+		if body := w.Body.String(); body != test.expected {
+			t.Errorf("Unexpected response of %s (%s):\n-> Expected: %s\n-> Found: %s",
+				test.path, test.acceptLanguage, test.expected, body)
+		}
+	}
+
+	if err := log.Close(); err != nil {
+		t.Errorf("error closing logger: %s", err)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	dir := setUpServer(t, `
+log_output_file="%[1]s/out.log"
+log_error_file="%[1]s/err.log"
+`)
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, config.SitesDirectory, "d.toml"), `
+id="d"
+sender_type="none"
+enabled=false
+maintenance_message="Closed for holidays"
+`)
+	writeFile(t, filepath.Join(dir, config.SitesDirectory, "e.toml"), `
+id="e"
+sender_type="none"
+language="es"
+`)
+	reloadConfig(nil, nil)
+
+	msg := func(s string) *string { return &s }
+	tests := []struct {
+		maintenance bool
+		message     *string
+		path        string
+		status      int
+		expected    string
+	}{
+		{false, nil, "/d", http.StatusServiceUnavailable, `{"success":false,"error":"Closed for holidays"}`},
+		{false, nil, "/v2/e", http.StatusBadRequest, `"code":"VALIDATION_FAILED"`},
+		{true, msg("Back soon"), "/v2/e", http.StatusServiceUnavailable,
+			`{"success":false,"code":"SERVICE_UNAVAILABLE","error":"Back soon"}`},
+		{true, nil, "/d", http.StatusServiceUnavailable, `"error":"Closed for holidays"`},
+		{false, nil, "/e", http.StatusBadRequest, `"error":"email no válido"`},
+		{true, nil, "/v2/e", http.StatusServiceUnavailable, `"error":"Back soon"`},
+		{true, msg(""), "/e", http.StatusServiceUnavailable, `"error":"servicio no disponible, inténtalo de nuevo más tarde"`},
+		{false, nil, "/e", http.StatusBadRequest, `"error":"email no válido"`},
+	}
+
+	for _, test := range tests {
+		if err := config.SetMaintenance(test.maintenance, test.message); err != nil {
+			t.Fatalf("error setting maintenance: %s", err)
+		}
+		reloadConfig(nil, nil)
+
+		r := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(`{"mail": "invalid"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handle(w, r)
+
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.expected) {
+			var message interface{} = "unchanged"
+			if test.message != nil {
+				message = *test.message
+			}
+			t.Errorf("Unexpected response of %s (maintenance %t %q):\n-> Expected: %d %s\n-> Found: %d %s",
+				test.path, test.maintenance, message, test.status, test.expected, w.Code, w.Body.String())
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, config.Filename))
+	if err != nil {
+		t.Fatalf("error reading config: %s", err)
+	}
+	if !strings.Contains(string(data), "log_output_file") || strings.Contains(string(data), "maintenance_message") {
+		t.Errorf("unexpected config after disabling maintenance:\n%s", data)
+	}
+
+	if err := log.Close(); err != nil {
+		t.Errorf("error closing logger: %s", err)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	dir := setUpServer(t, `
+log_output_file="%[1]s/out.log"
+log_error_file="%[1]s/err.log"
+`)
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, config.SitesDirectory, "f.toml"), `
+id="f"
+sender_type="none"
+retention_days=30
+`)
+	reloadConfig(nil, nil)
+
+	now := time.Now()
+	for _, r := range []*archive.Record{
+		{SiteID: "f", Time: now.AddDate(0, 0, -31)},
+		{SiteID: "f", Time: now.AddDate(0, 0, -29)},
+		{SiteID: "a", Time: now.AddDate(0, 0, -31)},
+		{SiteID: "d", Time: now.AddDate(0, 0, -11), RetentionDays: 10},
+		{SiteID: "d", Time: now.AddDate(0, 0, -9), RetentionDays: 10},
+		{SiteID: "f", Time: now.AddDate(0, 0, -11), RetentionDays: 10},
+	} {
+		if err := archive.Save(r); err != nil {
+			t.Fatalf("error archiving message: %s", err)
+		}
+	}
+
+	deleteExpired(now)
+	records, err := archive.List(archive.Filter{})
+	if err != nil {
+		t.Fatalf("error listing archived messages: %s", err)
+	}
+	if len(records) != 4 || records[0].ID != 2 || records[1].ID != 3 || records[2].ID != 5 || records[3].ID != 6 {
+		t.Errorf("unexpected archived messages: %+v", records)
+	}
+
+	if err := log.Close(); err != nil {
+		t.Errorf("error closing logger: %s", err)
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	site := &config.Site{ID: "a", RetentionDays: 30}
+	meta := templates.Request{ID: "1", ClientIP: "127.0.0.1"}
+	for _, test := range []struct {
+		pm                                      *pluginMsg
+		expectedName, expectedMail, expectedMsg string
+	}{
+		{&pluginMsg{Name: "Name", Mail: "mail@example.com", Msg: "msg"}, "Name", "mail@example.com", "msg"},
+		{&pluginMsg{Name: "Site", Msg: "-----BEGIN AGE ENCRYPTED FILE-----", encrypted: true},
+			"", "", "-----BEGIN AGE ENCRYPTED FILE-----"},
+	} {
+		r := newRecord(site, meta, test.pm)
+		if r.Name != test.expectedName || r.Mail != test.expectedMail || r.Msg != test.expectedMsg {
+			t.Errorf("unexpected record of %+v: %+v", test.pm, r)
+		}
+		if r.SiteID != "a" || r.RequestID != "1" || r.ClientIP != "127.0.0.1" || r.Outcome != archive.OutcomeSuccess ||
+			r.RetentionDays != 30 {
+			t.Errorf("unexpected metadata of record: %+v", r)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	conf.Store(&config.Config{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}})
+	for _, test := range []struct {
+		remoteAddr, realIP, expected string
+	}{
+		{"203.0.113.1:1234", "", "203.0.113.1"},
+		{"203.0.113.1:1234", "198.51.100.1", "203.0.113.1"},
+		{"127.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"[::1]:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.1.2.3:1234", "198.51.100.1", "198.51.100.1"},
+		{"192.168.1.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"192.168.1.2:1234", "198.51.100.1", "192.168.1.2"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.realIP != "" {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+		if ip := clientIP(r); ip != test.expected {
+			t.Errorf("unexpected client IP from %s with X-Real-IP %q: %s", test.remoteAddr, test.realIP, ip)
+		}
+	}
+}
+
+func TestSenderTimeout(t *testing.T) {
+	dir := setUpServer(t, `
+log_output_file="%[1]s/out.log"
+log_error_file="%[1]s/err.log"
+sender_timeout=1
+archive=true
+`)
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, config.PluginsDirectory, "sleep"+config.PluginExt), "setTimeout(() => {}, 10000);\n")
+	writeFile(t, filepath.Join(dir, config.SitesDirectory, "s.toml"), "id=\"s\"\nsender_type=\"sleep\"\n")
+	reloadConfig(nil, nil)
+
+	start := time.Now()
+	r := httptest.NewRequest(http.MethodPost, "/v2/s", strings.NewReader(`{"mail": "mail@example.com"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handle(w, r)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("unexpected response of sender that timed out: %d %s", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("sender not killed after sender_timeout: %s", elapsed)
+	}
+
+	records, err := archive.List(archive.Filter{SiteID: "s"})
+	if err != nil {
+		t.Fatalf("error listing archived messages: %s", err)
+	}
+	if len(records) != 1 || records[0].Outcome != archive.OutcomeTimeout {
+		t.Errorf("unexpected archived messages: %+v", records)
+	}
+
+	if err := log.Close(); err != nil {
+		t.Errorf("error closing logger: %s", err)
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"github.com/Miguel-Dorta/web-msg-handler/pkg/config"
+	"sync/atomic"
+)
+
+// siteRegistry holds the sites loaded, indexed by ID. Its content is never modified, but replaced atomically
+// with a new snapshot, so every request can keep using the snapshot it got while a reload is taking place.
+// The zero value is an empty registry.
+type siteRegistry struct {
+	v atomic.Value
+}
+
+// snapshot returns the sites currently loaded. The map returned must not be modified.
+func (sr *siteRegistry) snapshot() map[string]*config.Site {
+	s, _ := sr.v.Load().(map[string]*config.Site)
+	return s
+}
+
+// get returns the site with the ID provided from the current snapshot
+func (sr *siteRegistry) get(id string) (*config.Site, bool) {
+	site, ok := sr.snapshot()[id]
+	return site, ok
+}
+
+// swap replaces the sites loaded with the ones provided. The map provided must not be modified after this call.
+func (sr *siteRegistry) swap(sites map[string]*config.Site) {
+	sr.v.Store(sites)
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EnvInherited is the environment variable that lists the files inherited by a new instance when upgrading,
+	// as "name=fd" separated by commas
+	EnvInherited = "WEB_MSG_HANDLER_INHERITED"
+
+	// Names of the files inherited when upgrading
+	InheritedPort    = "port"
+	InheritedAdmin   = "admin"
+	InheritedControl = "control"
+	InheritedPIDFile = "pid-file"
+	inheritedReady   = "ready"
+
+	// UpgradeTimeout is the maximum time to wait for the new instance to be ready when upgrading
+	UpgradeTimeout = 30 * time.Second
+
+	// readyMsg is written by the new instance to the pipe of the previous one when it's ready
+	readyMsg = "ready\n"
+)
+
+var (
+	// Args are the arguments for starting an instance like this one, used for starting the new one when upgrading
+	Args []string
+
+	// PIDFile is the PID file of this instance, opened for reading and writing and locked. It's passed to the new
+	// instance when upgrading, so it keeps the lock. Upgrading is not possible without it (i.e. in foreground mode).
+	PIDFile *os.File
+
+	// listeners are the listeners of this instance by the name they're passed with when upgrading (see listen).
+	// They're only accessed by the goroutine of Run.
+	listeners = make(map[string]net.Listener)
+
+	// inheritedFiles are the files inherited from the previous instance (see Inherited)
+	inheritedFiles     map[string]*os.File
+	inheritedFilesOnce sync.Once
+)
+
+// Inherited returns the file with the name provided inherited from the previous instance when upgrading,
+// or nil if it doesn't exist. The files not taken when the server is ready are closed.
+func Inherited(name string) *os.File {
+	inheritedFilesOnce.Do(func() {
+		inheritedFiles = make(map[string]*os.File)
+		list := os.Getenv(EnvInherited)
+		// It must not be inherited by other programs, like the plugins
+		_ = os.Unsetenv(EnvInherited)
+		if list == "" {
+			return
+		}
+
+		for _, entry := range strings.Split(list, ",") {
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			fd, err := strconv.Atoi(parts[1])
+			if err != nil {
+				continue
+			}
+			unix.CloseOnExec(fd)
+			inheritedFiles[parts[0]] = os.NewFile(uintptr(fd), parts[0])
+		}
+	})
+
+	f := inheritedFiles[name]
+	delete(inheritedFiles, name)
+	return f
+}
+
+// listen returns the listener inherited from the previous instance with the name provided if it listens
+// in the address provided, or a new one otherwise (see listenUnix for unix sockets).
+// It's saved in listeners, so it can be passed to the next instance.
+func listen(name, network, addr string) (net.Listener, error) {
+	ln := inheritedListener(name, network, addr)
+	if ln == nil {
+		var err error
+		if network == "unix" {
+			ln, err = listenUnix(addr)
+		} else {
+			ln, err = net.Listen(network, addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	listeners[name] = ln
+	return ln, nil
+}
+
+// inheritedListener returns the listener inherited from the previous instance with the name provided,
+// or nil if it doesn't exist or it doesn't listen in the address provided.
+func inheritedListener(name, network, addr string) net.Listener {
+	f := Inherited(name)
+	if f == nil {
+		return nil
+	}
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		log.Errorf("error using listener %s inherited from the previous instance: %s", name, err)
+		return nil
+	}
+	if !listensIn(ln, network, addr) {
+		// The inherited unix sockets are never removed on close, since they can still be used by the previous instance
+		_ = ln.Close()
+		return nil
+	}
+
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(true)
+	}
+	log.Debugf("Using %s listener inherited from the previous instance", name)
+	return ln
+}
+
+// listensIn reports if the listener provided listens in the network and address provided
+func listensIn(ln net.Listener, network, addr string) bool {
+	switch a := ln.Addr().(type) {
+	case *net.UnixAddr:
+		return network == "unix" && a.Name == addr
+	case *net.TCPAddr:
+		want, err := net.ResolveTCPAddr(network, addr)
+		if err != nil || want.Port != a.Port {
+			return false
+		}
+		return want.IP.Equal(a.IP) || want.IP == nil && a.IP.IsUnspecified()
+	}
+	return false
+}
+
+// notifyReady tells the previous instance that this one is ready, if it was started by an upgrade,
+// and closes the files inherited that were not taken.
+func notifyReady() {
+	if f := Inherited(inheritedReady); f != nil {
+		if _, err := f.WriteString(readyMsg); err != nil {
+			log.Errorf("error notifying the previous instance: %s", err)
+		}
+		_ = f.Close()
+	}
+
+	for name, f := range inheritedFiles {
+		_ = f.Close()
+		delete(inheritedFiles, name)
+	}
+}
+
+// upgradeInstance starts a new instance with the current executable and Args, passing it the listeners and the PID file,
+// and waits until it's ready (see notifyReady). Then, it writes the PID of the new instance in the PID file and returns it.
+// If it fails, the new instance is killed, and this one must keep running.
+func upgradeInstance() (int, error) {
+	if PIDFile == nil {
+		return 0, errors.New("upgrading requires a PID file (not available in foreground mode)")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("error finding executable: %w", err)
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("error creating pipe: %w", err)
+	}
+	defer ready.Close()
+
+	// The files are placed after stdin, stdout and stderr
+	var (
+		files   = make([]*os.File, 0, len(listeners)+2)
+		names   = make([]string, 0, len(listeners)+2)
+		lnFiles = make([]*os.File, 0, len(listeners))
+	)
+	add := func(name string, f *os.File) {
+		names = append(names, name+"="+strconv.Itoa(3+len(files)))
+		files = append(files, f)
+	}
+	for _, name := range sortedListeners() {
+		f, err := listeners[name].(interface{ File() (*os.File, error) }).File()
+		if err != nil {
+			_ = readyW.Close()
+			return 0, fmt.Errorf("error getting file of listener %s: %w", name, err)
+		}
+		defer f.Close()
+		add(name, f)
+		lnFiles = append(lnFiles, f)
+	}
+	add(InheritedPIDFile, PIDFile)
+	add(inheritedReady, readyW)
+
+	cmd := exec.Command(exe, Args...)
+	cmd.Env = append(os.Environ(), EnvInherited+"="+strings.Join(names, ","))
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+	_ = readyW.Close()
+	// Passing the files sets them in blocking mode, which is shared with the listeners of this instance,
+	// that would not be able to close them if they're blocked accepting
+	for _, f := range lnFiles {
+		_ = unix.SetNonblock(int(f.Fd()), true)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error starting %s: %w", exe, err)
+	}
+	go func() {
+		_ = cmd.Wait()
+	}()
+
+	_ = ready.SetReadDeadline(time.Now().Add(UpgradeTimeout))
+	if msg, err := bufio.NewReader(ready).ReadString('\n'); err != nil || msg != readyMsg {
+		_ = cmd.Process.Kill()
+		return 0, fmt.Errorf("the new instance (PID %d) didn't become ready: %v", cmd.Process.Pid, err)
+	}
+
+	if err := writePID(PIDFile, cmd.Process.Pid); err != nil {
+		_ = cmd.Process.Kill()
+		return 0, fmt.Errorf("error writing PID file: %w", err)
+	}
+	return cmd.Process.Pid, nil
+}
+
+// sortedListeners returns the names of listeners sorted
+func sortedListeners() []string {
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keepSockets prevents the unix sockets of listeners from being removed when they're closed,
+// since they're used by the new instance after upgrading.
+func keepSockets() {
+	for _, ln := range listeners {
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+// writePID replaces the content of the PID file provided with the PID provided
+func writePID(f *os.File, pid int) error {
+	data := []byte(strconv.Itoa(pid) + "\n")
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return f.Truncate(int64(len(data)))
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListensIn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	defer ln.Close()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	anyLn, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	defer anyLn.Close()
+	anyPort := strconv.Itoa(anyLn.Addr().(*net.TCPAddr).Port)
+
+	for _, test := range []struct {
+		ln            net.Listener
+		network, addr string
+		expected      bool
+	}{
+		{ln, "tcp", "127.0.0.1:" + port, true},
+		{ln, "tcp", "127.0.0.2:" + port, false},
+		{ln, "tcp", ":" + port, false},
+		{ln, "unix", "127.0.0.1:" + port, false},
+		{anyLn, "tcp", ":" + anyPort, true},
+		{anyLn, "tcp", ":" + port, false},
+	} {
+		if listensIn(test.ln, test.network, test.addr) != test.expected {
+			t.Errorf("unexpected result for %s %s in %s: %t", test.network, test.addr, test.ln.Addr(), !test.expected)
+		}
+	}
+}
+
+func TestWritePID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.pid")
+	if err := ioutil.WriteFile(path, []byte("123456\n"), 0600); err != nil {
+		t.Fatalf("error writing PID file: %s", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("error opening PID file: %s", err)
+	}
+	defer f.Close()
+
+	if err := writePID(f, 42); err != nil {
+		t.Fatalf("error replacing PID: %s", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading PID file: %s", err)
+	}
+	if string(data) != "42\n" {
+		t.Errorf("unexpected PID file: %q", data)
+	}
+}
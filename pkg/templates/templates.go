@@ -0,0 +1,137 @@
+package templates
+// Package templates renders the messages with the templates defined by the site configs, so the senders don't have
+// to compose them.
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"path/filepath"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// Funcs are the helper functions available in every template:
+//
+// - escape: escapes the HTML reserved characters of a string. Only needed in text templates that produce HTML
+// (i.e. Telegram messages), since the HTML templates are escaped automatically.
+//
+// - truncate: truncates a string to the number of characters provided, adding "…" if it was truncated
+// (i.e. {{.Msg | truncate 100}}).
+//
+// - date: formats a time with the layout provided (see time.Format), i.e. {{.Request.Time | date "2006-01-02"}}.
+var Funcs = map[string]interface{}{
+	"escape":   html.EscapeString,
+	"truncate": truncate,
+	"date":     date,
+}
+
+// Data is the data available in the templates
+type Data struct {
+	// Name, Mail and Msg are the fields submitted by the visitor
+	Name, Mail, Msg string
+
+	// Site is the ID of the site the message was sent to
+	Site string
+
+	// Request is the metadata of the request of the message
+	Request Request
+}
+
+// Request is the metadata of the request of a message
+type Request struct {
+	ID, ClientIP, UserAgent, Origin, Referer, Language string
+	Time                                               time.Time
+}
+
+// Set are the templates of a site. Any of them can be nil if not defined.
+type Set struct {
+	Subject, Text *template.Template
+	HTML          *htmltemplate.Template
+}
+
+// Rendered is the result of rendering a Set. The messages of the templates not defined are empty.
+type Rendered struct {
+	Subject, Text, HTML string
+}
+
+// Load parses the template files provided. Empty paths are skipped, and relative paths are relative to dir.
+func Load(dir, subjectPath, textPath, htmlPath string) (*Set, error) {
+	var (
+		s   Set
+		err error
+	)
+
+	if subjectPath != "" {
+		if s.Subject, err = template.New(filepath.Base(subjectPath)).Funcs(Funcs).ParseFiles(abs(dir, subjectPath)); err != nil {
+			return nil, fmt.Errorf("error parsing subject template: %w", err)
+		}
+	}
+	if textPath != "" {
+		if s.Text, err = template.New(filepath.Base(textPath)).Funcs(Funcs).ParseFiles(abs(dir, textPath)); err != nil {
+			return nil, fmt.Errorf("error parsing text template: %w", err)
+		}
+	}
+	if htmlPath != "" {
+		if s.HTML, err = htmltemplate.New(filepath.Base(htmlPath)).Funcs(Funcs).ParseFiles(abs(dir, htmlPath)); err != nil {
+			return nil, fmt.Errorf("error parsing HTML template: %w", err)
+		}
+	}
+	return &s, nil
+}
+
+// Render executes the templates of the set with the data provided
+func (s *Set) Render(d *Data) (*Rendered, error) {
+	var (
+		r   Rendered
+		buf bytes.Buffer
+	)
+
+	if s.Subject != nil {
+		if err := s.Subject.Execute(&buf, d); err != nil {
+			return nil, fmt.Errorf("error rendering subject: %w", err)
+		}
+		r.Subject = buf.String()
+		buf.Reset()
+	}
+	if s.Text != nil {
+		if err := s.Text.Execute(&buf, d); err != nil {
+			return nil, fmt.Errorf("error rendering text: %w", err)
+		}
+		r.Text = buf.String()
+		buf.Reset()
+	}
+	if s.HTML != nil {
+		if err := s.HTML.Execute(&buf, d); err != nil {
+			return nil, fmt.Errorf("error rendering HTML: %w", err)
+		}
+		r.HTML = buf.String()
+	}
+	return &r, nil
+}
+
+// abs returns the path provided, relative to dir if it's not absolute
+func abs(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// truncate returns the first n characters of s, followed by "…" if s was longer
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n < 0 {
+		n = 0
+	}
+	return string([]rune(s)[:n]) + "…"
+}
+
+// date returns the time provided formatted with the layout provided
+func date(layout string, t time.Time) string {
+	return t.Format(layout)
+}
@@ -0,0 +1,56 @@
+package templates
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-msg-handler-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"subject.tmpl": `{{.Name | truncate 4}} ({{.Site}})`,
+		"text.tmpl":    `{{.Msg | escape}} {{.Request.Time | date "2006-01-02"}}`,
+		"html.tmpl":    `<p>{{.Msg}}</p>`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("error writing file %s: %s", name, err)
+		}
+	}
+
+	s, err := Load(dir, "subject.tmpl", "text.tmpl", filepath.Join(dir, "html.tmpl"))
+	if err != nil {
+		t.Fatalf("error loading templates: %s", err)
+	}
+
+	r, err := s.Render(&Data{
+		Name:    "Jöhnny",
+		Msg:     "<b>hi</b>",
+		Site:    "a",
+		Request: Request{Time: time.Date(2020, 5, 17, 0, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatalf("error rendering templates: %s", err)
+	}
+
+	expected := Rendered{
+		Subject: "Jöhn… (a)",
+		Text:    "&lt;b&gt;hi&lt;/b&gt; 2020-05-17",
+		HTML:    "<p>&lt;b&gt;hi&lt;/b&gt;</p>",
+	}
+	if *r != expected {
+		t.Errorf("Unexpected result:\n-> Expected: %+v\n-> Found: %+v", expected, *r)
+	}
+
+	if _, err := Load(dir, "", "missing.tmpl", ""); err == nil {
+		t.Error("missing template loaded")
+	}
+}
@@ -1,3 +1,4 @@
+const fs = require("fs");
 const nodemailer = require("nodemailer");
 
 // Settings is the object that contains the site settings.
@@ -32,19 +33,25 @@ class Settings {
 
 // Message is the interface that contains the message itself.
 // The object provided will always implement this interface.
+// subject, text and html are only provided when the site defines templates for them.
+// envelope is provided when web-msg-handler composes the email, which is written to the standard input.
 interface Message {
     name: string;
     mail: string;
     msg: string;
+    subject?: string;
+    text?: string;
+    html?: string;
+    envelope?: {from: string, to: string[]};
 }
 
 // escapeHTML escapes reserved characters in HTML
 function escapeHTML(s: string) : string {
-    return s.replace("&", "&amp;")
-        .replace("'", "&#39;")
-        .replace("<", "&lt;")
-        .replace(">", "&gt;")
-        .replace("\"", "&#34;")
+    return s.replace(/&/g, "&amp;")
+        .replace(/'/g, "&#39;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&#34;");
 }
 
 // composeMsg creates the string message that will be sent
@@ -52,7 +59,7 @@ function composeMsg(sett: Settings, msg: Message) : string {
     let webName = escapeHTML(sett.webName);
     let name = escapeHTML(msg.name);
     let mail = escapeHTML(msg.mail);
-    let escapedMsg = escapeHTML(msg.msg).replace("\n", "<br>");
+    let escapedMsg = escapeHTML(msg.msg).replace(/\n/g, "<br>");
     return `<html><body>Message from ${webName}<br><br><b>Name:</b> ${name}<br><b>Email:</b> ${mail}<br><b>Message:</b> ${escapedMsg}</body></html>`
 }
 
@@ -67,11 +74,19 @@ function send(sett: Settings, msg: Message) {
         }
     });
 
+    if (msg.envelope) {
+        return transporter.sendMail({
+            envelope: msg.envelope,
+            raw: fs.readFileSync(0)
+        });
+    }
+
+    let body = msg.text || msg.html ? {text: msg.text, html: msg.html} : {html: composeMsg(sett, msg)};
     return transporter.sendMail({
         from: sett.username,
         to: sett.mailto,
-        subject: "Message from " + sett.webName,
-        html: composeMsg(sett, msg)
+        subject: msg.subject || "Message from " + sett.webName,
+        ...body
     });
 }
 
@@ -24,19 +24,21 @@ class Settings {
 
 // Message is the interface that contains the message itself.
 // The object provided will always implement this interface.
+// text is only provided when the site defines a template for it, and it must be valid Telegram HTML.
 interface Message {
     name: string;
     mail: string;
     msg: string;
+    text?: string;
 }
 
 // escapeHTML escapes reserved characters in HTML
 function escapeHTML(s: string) : string {
-    return s.replace("&", "&amp;")
-            .replace("'", "&#39;")
-            .replace("<", "&lt;")
-            .replace(">", "&gt;")
-            .replace("\"", "&#34;");
+    return s.replace(/&/g, "&amp;")
+            .replace(/'/g, "&#39;")
+            .replace(/</g, "&lt;")
+            .replace(/>/g, "&gt;")
+            .replace(/"/g, "&#34;");
 }
 
 // composeMsg creates the string message that will be sent
@@ -54,7 +56,7 @@ function send(sett: Settings, msg: Message) {
     // compose data to send
     let data = JSON.stringify({
         "chat_id": sett.chatID,
-        "text": composeMsg(sett, msg),
+        "text": msg.text || composeMsg(sett, msg),
         "parse_mode": "HTML",
         "disable_web_page_preview": true,
     });